@@ -4,11 +4,15 @@ package main
 import (
 	"github.com/viam-modules/controlledcomponents"
 	"go.viam.com/rdk/components/base"
+	"go.viam.com/rdk/components/sensor"
 	"go.viam.com/rdk/module"
 	"go.viam.com/rdk/resource"
 )
 
 func main() {
 	// ModularMain can take multiple APIModel arguments, if your module implements multiple models.
-	module.ModularMain(resource.APIModel{base.API, controlledcomponents.SensorControlledModel})
+	module.ModularMain(
+		resource.APIModel{API: base.API, Model: controlledcomponents.SensorControlledModel},
+		resource.APIModel{API: sensor.API, Model: controlledcomponents.ControlDiagnosticsModel},
+	)
 }
@@ -1,6 +1,8 @@
 package controlledcomponents
 
 import (
+	"slices"
+
 	"github.com/pkg/errors"
 	"go.viam.com/rdk/control"
 	"go.viam.com/rdk/resource"
@@ -10,14 +12,55 @@ var (
 	family = resource.NewModelFamily("viam", "controlled-components")
 	// SensorControlledModel is the name of the sensor_controlled model of a base component.
 	SensorControlledModel = family.WithModel("sensor-controlled")
+	// ControlDiagnosticsModel is the name of the control-diagnostics model of a sensor component.
+	ControlDiagnosticsModel = family.WithModel("control-diagnostics")
 )
 
 // SCBConfig configures a sensor controlled base.
 type SCBConfig struct {
-	MovementSensor    []string            `json:"movement_sensor"`
-	Base              string              `json:"base"`
-	ControlParameters []control.PIDConfig `json:"control_parameters,omitempty"`
-	ControlFreq       float64             `json:"control_frequency_hz,omitempty"`
+	MovementSensor       []string            `json:"movement_sensor"`
+	Base                 string              `json:"base"`
+	ControlParameters    []control.PIDConfig `json:"control_parameters,omitempty"`
+	GainSchedule         []GainBandConfig    `json:"gain_schedule,omitempty"`
+	Feedforward          []FeedforwardConfig `json:"feedforward,omitempty"`
+	Deadband             []DeadbandConfig    `json:"deadband,omitempty"`
+	ControlFreq          float64             `json:"control_frequency_hz,omitempty"`
+	FuseSensors          bool                `json:"fuse_sensors,omitempty"`
+	SensorWeights        map[string]float64  `json:"sensor_weights,omitempty"`
+	SensorPositionFrames map[string]string   `json:"sensor_position_frames,omitempty"`
+	TunedGainsFile       string              `json:"tuned_gains_file,omitempty"`
+	HeadingHold          bool                `json:"heading_hold,omitempty"`
+	MotionProfile        string              `json:"motion_profile,omitempty"`
+	MaxLinearAccel       float64             `json:"max_linear_accel,omitempty"`
+	MaxAngularAccel      float64             `json:"max_angular_accel,omitempty"`
+	MaxLinearJerk        float64             `json:"max_linear_jerk,omitempty"`
+	MaxAngularJerk       float64             `json:"max_angular_jerk,omitempty"`
+
+	SpinToleranceDeg      float64 `json:"spin_tolerance_deg,omitempty"`
+	SpinSlowDownGain      float64 `json:"spin_slow_down_gain,omitempty"`
+	MaxSpinSlowDownDeg    float64 `json:"max_spin_slow_down_deg,omitempty"`
+	StraightSlowDownGain  float64 `json:"straight_slow_down_gain,omitempty"`
+	MaxStraightSlowDownMm float64 `json:"max_straight_slow_down_mm,omitempty"`
+	HeadingGain           float64 `json:"heading_gain,omitempty"`
+	TimeoutMultiplier     float64 `json:"timeout_multiplier,omitempty"`
+	MinTimeoutSec         float64 `json:"min_timeout_sec,omitempty"`
+	OvershootToleranceMm  float64 `json:"overshoot_tolerance_mm,omitempty"`
+	PositionFrame         string  `json:"position_frame,omitempty"`
+
+	RunLogDir       string  `json:"run_log_dir,omitempty"`
+	RunLogFormat    string  `json:"run_log_format,omitempty"`
+	RunLogMaxFiles  int     `json:"run_log_max_files,omitempty"`
+	RunLogMaxFileMB float64 `json:"run_log_max_file_mb,omitempty"`
+
+	StallWindowSec         float64             `json:"stall_window_sec,omitempty"`
+	StallPower             float64             `json:"stall_power,omitempty"`
+	StallLinearMmPerSec    float64             `json:"stall_linear_mm_per_sec,omitempty"`
+	StallAngularDegsPerSec float64             `json:"stall_angular_degs_per_sec,omitempty"`
+	VelocityCommandTimeout float64             `json:"velocity_command_timeout,omitempty"`
+	StaleSensor            []StaleSensorConfig `json:"stale_sensor,omitempty"`
+	Geofence               *GeofenceConfig     `json:"geofence,omitempty"`
+	MaxRollDeg             float64             `json:"max_roll_deg,omitempty"`
+	MaxPitchDeg            float64             `json:"max_pitch_deg,omitempty"`
 }
 
 // Validate validates all parts of the sensor controlled base config.
@@ -34,11 +77,323 @@ func (cfg *SCBConfig) Validate(path string) ([]string, error) {
 	deps = append(deps, cfg.Base)
 
 	for _, pidConf := range cfg.ControlParameters {
-		if pidConf.Type != typeLinVel && pidConf.Type != typeAngVel {
+		if pidConf.Type != typeLinVel && pidConf.Type != typeAngVel && pidConf.Type != typeLatVel {
+			return nil, resource.NewConfigValidationError(path,
+				errors.New("control_parameters type must be 'linear_velocity' or 'angular_velocity'"+
+					" (or 'lateral_velocity' for holonomic bases)"))
+		}
+		// unlike the other axes, the lateral axis is not auto-tuned when the base is configured
+		if pidConf.Type == typeLatVel && pidConf.NeedsAutoTuning() {
+			return nil, resource.NewConfigValidationError(path,
+				errors.New("lateral_velocity gains cannot all be 0, set starting gains and tune them with the start_tuning DoCommand"))
+		}
+	}
+
+	for name, weight := range cfg.SensorWeights {
+		if !slices.Contains(cfg.MovementSensor, name) {
 			return nil, resource.NewConfigValidationError(path,
-				errors.New("control_parameters type must be 'linear_velocity' or 'angular_velocity'"))
+				errors.Errorf("sensor_weights contains %s, which is not a configured movement_sensor", name))
 		}
+		if weight <= 0 {
+			return nil, resource.NewConfigValidationError(path,
+				errors.Errorf("sensor_weights for %s must be greater than 0", name))
+		}
+	}
+
+	if err := cfg.validatePositionFrames(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+
+	if err := cfg.validateMotionProfile(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.motionTunables().validate(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if cfg.OvershootToleranceMm < 0 {
+		return nil, resource.NewConfigValidationError(path, errors.New("overshoot_tolerance_mm cannot be negative"))
+	}
+	if err := cfg.validateRunLog(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateFeedforward(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateGainSchedule(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateDeadband(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateSafety(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateStaleSensor(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+	if err := cfg.validateGeofence(); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
 	}
 
 	return deps, nil
 }
+
+// validatePositionFrames checks that each declared sensor position frame names a configured movement sensor
+// and a known frame, and that sensors fused together all report in the same frame.
+func (cfg *SCBConfig) validatePositionFrames() error {
+	if cfg.PositionFrame != "" && cfg.PositionFrame != frameGeodetic && cfg.PositionFrame != frameLocal {
+		return errors.Errorf("position_frame must be '%s' or '%s'", frameGeodetic, frameLocal)
+	}
+	frames := map[string]bool{}
+	if cfg.PositionFrame != "" {
+		frames[cfg.PositionFrame] = true
+	}
+	for name, frame := range cfg.SensorPositionFrames {
+		if !slices.Contains(cfg.MovementSensor, name) {
+			return errors.Errorf("sensor_position_frames contains %s, which is not a configured movement_sensor", name)
+		}
+		if frame != frameGeodetic && frame != frameLocal {
+			return errors.Errorf("sensor_position_frames for %s must be '%s' or '%s'", name, frameGeodetic, frameLocal)
+		}
+		frames[frame] = true
+	}
+	if cfg.FuseSensors && len(frames) > 1 {
+		return errors.New("fuse_sensors cannot fuse position sensors that report in different frames, " +
+			"set sensor_position_frames and position_frame to the same frame or disable fuse_sensors")
+	}
+	return nil
+}
+
+// validateMotionProfile checks that the limits needed by the configured motion profile are set.
+func (cfg *SCBConfig) validateMotionProfile() error {
+	limits := map[string]float64{
+		"max_linear_accel":  cfg.MaxLinearAccel,
+		"max_angular_accel": cfg.MaxAngularAccel,
+		"max_linear_jerk":   cfg.MaxLinearJerk,
+		"max_angular_jerk":  cfg.MaxAngularJerk,
+	}
+	for name, limit := range limits {
+		if limit < 0 {
+			return errors.Errorf("%s cannot be negative", name)
+		}
+	}
+
+	var required []string
+	switch cfg.MotionProfile {
+	case "", profileNone:
+	case profileTrapezoidal:
+		required = []string{"max_linear_accel", "max_angular_accel"}
+	case profileSCurve:
+		required = []string{"max_linear_accel", "max_angular_accel", "max_linear_jerk", "max_angular_jerk"}
+	default:
+		return errors.Errorf("motion_profile must be '%s', '%s' or '%s'", profileNone, profileTrapezoidal, profileSCurve)
+	}
+	for _, name := range required {
+		if limits[name] == 0 {
+			return errors.Errorf("%s must be set for the %s motion_profile", name, cfg.MotionProfile)
+		}
+	}
+	return nil
+}
+
+// validateRunLog checks the run log attributes of the config.
+func (cfg *SCBConfig) validateRunLog() error {
+	if cfg.RunLogFormat != "" && cfg.RunLogFormat != runLogCSV && cfg.RunLogFormat != runLogJSON {
+		return errors.Errorf("run_log_format must be '%s' or '%s'", runLogCSV, runLogJSON)
+	}
+	if cfg.RunLogMaxFiles < 0 {
+		return errors.New("run_log_max_files cannot be negative")
+	}
+	if cfg.RunLogMaxFileMB < 0 {
+		return errors.New("run_log_max_file_mb cannot be negative")
+	}
+	return nil
+}
+
+// FeedforwardConfig holds the feedforward gains of one axis, which are added to the output of its PID.
+// The gains map the setpoint to a power between -1 and 1, with linear setpoints in m/s and angular setpoints in deg/s.
+type FeedforwardConfig struct {
+	Type string  `json:"type"`
+	KV   float64 `json:"kv"`
+	KA   float64 `json:"ka"`
+	KS   float64 `json:"ks"`
+}
+
+// validateFeedforward checks that each axis has at most one set of feedforward gains and that no gain is negative.
+func (cfg *SCBConfig) validateFeedforward() error {
+	seen := map[string]bool{}
+	for _, ff := range cfg.Feedforward {
+		if ff.Type != typeLinVel && ff.Type != typeAngVel {
+			return errors.New("feedforward type must be 'linear_velocity' or 'angular_velocity'")
+		}
+		if seen[ff.Type] {
+			return errors.Errorf("feedforward contains %s more than once", ff.Type)
+		}
+		seen[ff.Type] = true
+		if ff.KV < 0 || ff.KA < 0 || ff.KS < 0 {
+			return errors.Errorf("feedforward gains for %s cannot be negative", ff.Type)
+		}
+	}
+	return nil
+}
+
+// DeadbandConfig holds the smallest power that moves one axis of the base.
+type DeadbandConfig struct {
+	Type  string  `json:"type"`
+	Power float64 `json:"power"`
+}
+
+// validateDeadband checks that each axis has at most one deadband and that it is a power between 0 and 1.
+func (cfg *SCBConfig) validateDeadband() error {
+	seen := map[string]bool{}
+	for _, db := range cfg.Deadband {
+		if db.Type != typeLinVel && db.Type != typeAngVel {
+			return errors.New("deadband type must be 'linear_velocity' or 'angular_velocity'")
+		}
+		if seen[db.Type] {
+			return errors.Errorf("deadband contains %s more than once", db.Type)
+		}
+		seen[db.Type] = true
+		if db.Power < 0 || db.Power >= 1 {
+			return errors.Errorf("deadband power for %s must be at least 0 and less than 1", db.Type)
+		}
+	}
+	return nil
+}
+
+// GainBandConfig holds the PID gains one axis switches to once the magnitude of its setpoint reaches MinSpeed,
+// in mm/s for linear_velocity and deg/s for angular_velocity. Below the slowest band the control_parameters gains are used.
+type GainBandConfig struct {
+	Type     string  `json:"type"`
+	MinSpeed float64 `json:"min_speed"`
+	P        float64 `json:"p"`
+	I        float64 `json:"i"`
+	D        float64 `json:"d"`
+}
+
+// validateGainSchedule checks that each band is for a scheduled axis and has gains,
+// and that no two bands of an axis start at the same speed.
+func (cfg *SCBConfig) validateGainSchedule() error {
+	seen := map[string]map[float64]bool{typeLinVel: {}, typeAngVel: {}}
+	for _, band := range cfg.GainSchedule {
+		if band.Type != typeLinVel && band.Type != typeAngVel {
+			return errors.New("gain_schedule type must be 'linear_velocity' or 'angular_velocity'")
+		}
+		if band.MinSpeed <= 0 {
+			return errors.Errorf("gain_schedule min_speed for %s must be greater than 0", band.Type)
+		}
+		// bands are not auto-tuned when the base is configured
+		if pid := band.pid(); pid.NeedsAutoTuning() {
+			return errors.Errorf("gain_schedule %s band at min_speed %v cannot have all zero gains, "+
+				"set starting gains and tune them with the start_tuning DoCommand", band.Type, band.MinSpeed)
+		}
+		if seen[band.Type][band.MinSpeed] {
+			return errors.Errorf("gain_schedule contains more than one %s band at min_speed %v", band.Type, band.MinSpeed)
+		}
+		seen[band.Type][band.MinSpeed] = true
+	}
+	return nil
+}
+
+// validateSafety checks the stall detector, watchdog and tilt attributes of the config.
+func (cfg *SCBConfig) validateSafety() error {
+	limits := map[string]float64{
+		"stall_window_sec":           cfg.StallWindowSec,
+		"stall_power":                cfg.StallPower,
+		"stall_linear_mm_per_sec":    cfg.StallLinearMmPerSec,
+		"stall_angular_degs_per_sec": cfg.StallAngularDegsPerSec,
+		"velocity_command_timeout":   cfg.VelocityCommandTimeout,
+		"max_roll_deg":               cfg.MaxRollDeg,
+		"max_pitch_deg":              cfg.MaxPitchDeg,
+	}
+	for name, limit := range limits {
+		if limit < 0 {
+			return errors.Errorf("%s cannot be negative", name)
+		}
+	}
+	if cfg.StallPower > 1 {
+		return errors.New("stall_power cannot be greater than 1")
+	}
+	if cfg.MaxRollDeg > 90 || cfg.MaxPitchDeg > 90 {
+		return errors.New("max_roll_deg and max_pitch_deg cannot be greater than 90")
+	}
+	return nil
+}
+
+// StaleSensorConfig holds the staleness limits of the readings of one sensor role, velocity, heading or position.
+// A reading is stale once it has not changed for Ticks ticks, or for MaxAgeSec seconds, while the base is driven.
+type StaleSensorConfig struct {
+	Type      string  `json:"type"`
+	Ticks     int     `json:"ticks,omitempty"`
+	MaxAgeSec float64 `json:"max_age_sec,omitempty"`
+}
+
+// validateStaleSensor checks that each sensor role has at most one set of staleness limits and that each sets a limit.
+func (cfg *SCBConfig) validateStaleSensor() error {
+	seen := map[string]bool{}
+	for _, sc := range cfg.StaleSensor {
+		if sc.Type != staleVelocity && sc.Type != staleHeading && sc.Type != stalePosition {
+			return errors.Errorf("stale_sensor type must be '%s', '%s' or '%s'", staleVelocity, staleHeading, stalePosition)
+		}
+		if seen[sc.Type] {
+			return errors.Errorf("stale_sensor contains %s more than once", sc.Type)
+		}
+		seen[sc.Type] = true
+		if sc.Ticks < 0 || sc.MaxAgeSec < 0 {
+			return errors.Errorf("stale_sensor limits for %s cannot be negative", sc.Type)
+		}
+		if sc.Ticks == 0 && sc.MaxAgeSec == 0 {
+			return errors.Errorf("stale_sensor for %s needs ticks or max_age_sec", sc.Type)
+		}
+	}
+	return nil
+}
+
+// GeofenceConfig holds the area the base is kept in, either a polygon of points or a circle of RadiusM around Home.
+// In the local position frame each point holds the Y position in meters as its lat and the X position as its lng.
+type GeofenceConfig struct {
+	Polygon []GeoPointConfig `json:"polygon,omitempty"`
+	Home    *GeoPointConfig  `json:"home,omitempty"`
+	RadiusM float64          `json:"radius_m,omitempty"`
+	MarginM float64          `json:"margin_m,omitempty"`
+}
+
+// GeoPointConfig is a point of a geofence.
+type GeoPointConfig struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// validateGeofence checks that the geofence is either a polygon or a circle around a home point.
+func (cfg *SCBConfig) validateGeofence() error {
+	gf := cfg.Geofence
+	if gf == nil {
+		return nil
+	}
+	if (len(gf.Polygon) == 0) == (gf.Home == nil) {
+		return errors.New("geofence needs either a polygon or a home and radius_m")
+	}
+	if gf.Home != nil && gf.RadiusM <= 0 {
+		return errors.New("geofence radius_m must be greater than 0")
+	}
+	if len(gf.Polygon) != 0 && len(gf.Polygon) < 3 {
+		return errors.New("geofence polygon needs at least 3 points")
+	}
+	if gf.MarginM < 0 {
+		return errors.New("geofence margin_m cannot be negative")
+	}
+	return nil
+}
+
+// DiagnosticsConfig configures a sensor that reports the controller state of a sensor controlled base.
+type DiagnosticsConfig struct {
+	Base string `json:"base"`
+}
+
+// Validate validates the control diagnostics config.
+func (cfg *DiagnosticsConfig) Validate(path string) ([]string, error) {
+	if cfg.Base == "" {
+		return nil, resource.NewConfigValidationFieldRequiredError(path, "base")
+	}
+	return []string{cfg.Base}, nil
+}
@@ -0,0 +1,57 @@
+package controlledcomponents
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/base"
+	"go.viam.com/rdk/components/sensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/resource"
+)
+
+func init() {
+	resource.RegisterComponent(
+		sensor.API,
+		ControlDiagnosticsModel,
+		resource.Registration[sensor.Sensor, *DiagnosticsConfig]{Constructor: newControlDiagnostics})
+}
+
+// controlDiagnostics is a sensor that reports the controller state of a sensor controlled base,
+// so that data capture can record the health of the controller over time.
+// The state is fetched with the base's get_diagnostics DoCommand, as a module's dependencies
+// are clients of the resources rather than the resources themselves.
+type controlDiagnostics struct {
+	resource.Named
+	resource.AlwaysRebuild
+	resource.TriviallyCloseable
+	base base.Base
+}
+
+func newControlDiagnostics(ctx context.Context, deps resource.Dependencies, rawConf resource.Config, logger logging.Logger,
+) (sensor.Sensor, error) {
+	conf, err := resource.NativeConfig[*DiagnosticsConfig](rawConf)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base.FromDependencies(deps, conf.Base)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no base named (%s)", conf.Base)
+	}
+	return &controlDiagnostics{Named: rawConf.ResourceName().AsNamed(), base: b}, nil
+}
+
+// Readings returns the setpoints, measured velocities, heading, active operation, tuning status,
+// loop timing and sensor roles of the base.
+func (cd *controlDiagnostics) Readings(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := cd.base.DoCommand(ctx, map[string]interface{}{getDiagnostics: true})
+	if err != nil {
+		return nil, err
+	}
+	readings, ok := resp[getDiagnostics].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("base %s did not return diagnostics, it must be a %s base",
+			cd.base.Name().ShortName(), SensorControlledModel.Name)
+	}
+	return readings, nil
+}
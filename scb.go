@@ -22,11 +22,14 @@ import (
 const (
 	yawPollTime        = 5 * time.Millisecond
 	velocitiesPollTime = 5 * time.Millisecond
-	sensorDebug        = false
 	typeLinVel         = "linear_velocity"
 	typeAngVel         = "angular_velocity"
 	defaultControlFreq = 10 // Hz
 	getPID             = "get_tuned_pid"
+	startTuning        = "start_tuning"
+	cancelTuning       = "cancel_tuning"
+	getStraightErr     = "get_move_straight_error"
+	getDiagnostics     = "get_diagnostics"
 )
 
 var errNoGoodSensor = errors.New("no appropriate sensor for orientation or velocity feedback")
@@ -52,6 +55,11 @@ type sensorBase struct {
 	allSensors []movementsensor.MovementSensor
 	velocities movementsensor.MovementSensor
 	position   movementsensor.MovementSensor
+	compass    movementsensor.MovementSensor
+	// orientation is only kept to find the course heading and report which sensor fills the role, headingFunc reads it
+	orientation movementsensor.MovementSensor
+	// positionFrame is whether the position sensor reports geodetic or local positions
+	positionFrame string
 	// headingFunc returns the current angle between (-180,180) and whether Spin is supported
 	headingFunc func(ctx context.Context) (float64, bool, error)
 
@@ -60,7 +68,30 @@ type sensorBase struct {
 	loop              *control.Loop
 	configPIDVals     []control.PIDConfig
 	tunedVals         *[]control.PIDConfig
+	lateral           *lateralControl
+	ff                *feedforward
+	saturation        *saturation
+	safety            *safetyMonitor
+	// deadband is the smallest power that moves the linear and angular axes
+	deadband          [2]float64
+	schedule          *gainSchedule
+	geofence          *geofence
 	controlFreq       float64
+	gainsFile         string
+	tuneCancel        context.CancelFunc
+	tuneDone          chan struct{}
+	headingHoldCancel context.CancelFunc
+	headingHoldDone   chan struct{}
+	watchdogCancel    context.CancelFunc
+	watchdogDone      chan struct{}
+	// lastStraightErrMm is the signed distance from the goal the last MoveStraight finished at
+	lastStraightErrMm *float64
+	waypoints         waypointProgress
+	history           *controlHistory
+	runLogs           runLogConfig
+
+	cancelCtx  context.Context
+	cancelFunc context.CancelFunc
 }
 
 func newSCB(ctx context.Context, deps resource.Dependencies, rawConf resource.Config, logger logging.Logger) (base.Base, error) {
@@ -76,12 +107,18 @@ func newSCB(ctx context.Context, deps resource.Dependencies, rawConf resource.Co
 func NewSensorControlled(ctx context.Context, deps resource.Dependencies,
 	name resource.Name, conf *SCBConfig, logger logging.Logger,
 ) (base.Base, error) {
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
 	sb := &sensorBase{
 		logger:        logger,
 		tunedVals:     &[]control.PIDConfig{{}, {}},
 		configPIDVals: []control.PIDConfig{{}, {}},
 		name:          name,
 		opMgr:         operation.NewSingleOperationManager(),
+		history:       newControlHistory(controlHistorySize),
+		saturation:    &saturation{},
+		safety:        &safetyMonitor{},
+		cancelCtx:     cancelCtx,
+		cancelFunc:    cancelFunc,
 	}
 
 	if err := sb.reconfigureWithConfig(ctx, deps, conf); err != nil {
@@ -102,10 +139,10 @@ func (sb *sensorBase) Reconfigure(ctx context.Context, deps resource.Dependencie
 
 func (sb *sensorBase) reconfigureWithConfig(ctx context.Context, deps resource.Dependencies, newConf *SCBConfig) error {
 	var err error
-	if sb.loop != nil {
-		sb.loop.Stop()
-		sb.loop = nil
-	}
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	sb.stopControlLoop()
+	sb.stopRun()
 
 	sb.mu.Lock()
 	defer sb.mu.Unlock()
@@ -114,12 +151,25 @@ func (sb *sensorBase) reconfigureWithConfig(ctx context.Context, deps resource.D
 	if newConf.ControlFreq != 0 {
 		sb.controlFreq = newConf.ControlFreq
 	}
+	sb.gainsFile = tunedGainsPath(newConf)
+	sb.runLogs = newRunLogConfig(newConf)
+	sb.ff = newFeedforward(newConf.Feedforward)
+	sb.schedule = newGainSchedule(newConf.GainSchedule)
+	sb.safety.configure(newSafetyConfig(newConf))
+	sb.geofence = newGeofence(newConf.Geofence)
+	// calibrated deadbands are not saved, the config is the only source of the deadband
+	sb.deadband = [2]float64{}
+	for _, db := range newConf.Deadband {
+		if db.Type == typeLinVel {
+			sb.deadband[0] = db.Power
+		} else {
+			sb.deadband[1] = db.Power
+		}
+	}
 
 	// reset all sensors
 	sb.allSensors = nil
 	sb.velocities = nil
-	var orientation movementsensor.MovementSensor
-	var compassHeading movementsensor.MovementSensor
 	sb.position = nil
 	sb.controlledBase = nil
 
@@ -131,58 +181,59 @@ func (sb *sensorBase) reconfigureWithConfig(ctx context.Context, deps resource.D
 		sb.allSensors = append(sb.allSensors, ms)
 	}
 
+	// collect every sensor that does not error and satisfies the properties wanted for each role
+	var orientations, velocities, positions, compassHeadings []movementsensor.MovementSensor
 	for _, ms := range sb.allSensors {
 		props, err := ms.Properties(context.Background(), nil)
-		if err == nil && props.OrientationSupported {
-			// return first sensor that does not error that satisfies the properties wanted
-			orientation = ms
-			sb.logger.CInfof(ctx, "using sensor %s as orientation sensor for base", orientation.Name().ShortName())
-			break
+		if err != nil {
+			continue
 		}
-	}
-
-	for _, ms := range sb.allSensors {
-		props, err := ms.Properties(context.Background(), nil)
-		if err == nil && props.AngularVelocitySupported && props.LinearVelocitySupported {
-			// return first sensor that does not error that satisfies the properties wanted
-			sb.velocities = ms
-			sb.logger.CInfof(ctx, "using sensor %s as velocity sensor for base", sb.velocities.Name().ShortName())
-			break
+		if props.OrientationSupported {
+			orientations = append(orientations, ms)
 		}
-	}
-
-	for _, ms := range sb.allSensors {
-		props, err := ms.Properties(context.Background(), nil)
-		if err == nil && props.PositionSupported {
-			// return first sensor that does not error that satisfies the properties wanted
-			sb.position = ms
-			sb.logger.CInfof(ctx, "using sensor %s as position sensor for base", sb.position.Name().ShortName())
-			break
+		if props.AngularVelocitySupported && props.LinearVelocitySupported {
+			velocities = append(velocities, ms)
 		}
-	}
-
-	for _, ms := range sb.allSensors {
-		props, err := ms.Properties(context.Background(), nil)
-		if err == nil && props.CompassHeadingSupported {
-			// return first sensor that does not error that satisfies the properties wanted
-			compassHeading = ms
-			sb.logger.CInfof(ctx, "using sensor %s as compassHeading sensor for base", compassHeading.Name().ShortName())
-			break
+		if props.PositionSupported {
+			positions = append(positions, ms)
+		}
+		if props.CompassHeadingSupported {
+			compassHeadings = append(compassHeadings, ms)
 		}
 	}
+
+	orientation := sb.selectSensor(ctx, "orientation", orientations, newConf)
+	sb.velocities = sb.selectSensor(ctx, "velocity", velocities, newConf)
+	// neither fusion nor failover can move between GPS coordinates and the meters of odometry
+	positions = sb.samePositionFrame(ctx, positions, newConf)
+	sb.position = sb.selectSensor(ctx, "position", positions, newConf)
+	compassHeading := sb.selectSensor(ctx, "compassHeading", compassHeadings, newConf)
+	sb.compass = compassHeading
+	sb.orientation = orientation
 	sb.determineHeadingFunc(ctx, orientation, compassHeading)
+	sb.positionFrame = sb.selectPositionFrame(ctx, newConf)
+	if fs, ok := sb.position.(*failoverSensor); ok && sb.positionFrame == frameLocal {
+		fs.alignLocalPositions()
+	}
 
 	if orientation == nil && sb.velocities == nil {
 		return errNoGoodSensor
 	}
+	if sb.geofence != nil && sb.position == nil {
+		return errors.New("geofence requires a position sensor")
+	}
+	if (newConf.MaxRollDeg > 0 || newConf.MaxPitchDeg > 0) && orientation == nil {
+		return errors.New("max_roll_deg and max_pitch_deg require an orientation sensor")
+	}
 
 	sb.controlledBase, err = base.FromDependencies(deps, newConf.Base)
 	if err != nil {
 		return errors.Wrapf(err, "no base named (%s)", newConf.Base)
 	}
 
+	sb.lateral = nil
 	if sb.velocities != nil && len(newConf.ControlParameters) != 0 {
-		// assign linear and angular PID correctly based on the given type
+		// assign linear, angular and lateral PID correctly based on the given type
 		for _, pidConf := range newConf.ControlParameters {
 			switch pidConf.Type {
 			case typeLinVel:
@@ -191,12 +242,30 @@ func (sb *sensorBase) reconfigureWithConfig(ctx context.Context, deps resource.D
 			case typeAngVel:
 				// configPIDVals at index 1 is angular
 				sb.configPIDVals[1] = pidConf
+			case typeLatVel:
+				// the lateral axis of holonomic bases runs in its own loop
+				sb.lateral = &lateralControl{conf: pidConf}
 			default:
-				return fmt.Errorf("control_parameters type '%v' not accepted, type must be 'linear_velocity' or 'angular_velocity'",
-					pidConf.Type)
+				return fmt.Errorf("control_parameters type '%v' not accepted, type must be 'linear_velocity' or 'angular_velocity'"+
+					" (or 'lateral_velocity' for holonomic bases)", pidConf.Type)
 			}
 		}
 
+		// gains saved by a previous tuning run are used instead of tuning again
+		needsTuning := []bool{sb.configPIDVals[0].NeedsAutoTuning(), sb.configPIDVals[1].NeedsAutoTuning()}
+		sb.applySavedGains(ctx)
+		// tuning starts as soon as the control loop is set up, so its run has to start first
+		var tuningRun *runLog
+		var tuningAxes []interface{}
+		for i, pidConf := range sb.configPIDVals {
+			if pidConf.NeedsAutoTuning() {
+				tuningAxes = append(tuningAxes, axisName(i))
+			}
+		}
+		if len(tuningAxes) != 0 {
+			tuningRun = sb.startRun(ctx, runTuning, map[string]interface{}{"axes": tuningAxes})
+		}
+
 		// unlock the mutex before setting up the control loop so that the motors
 		// are not locked, and can run if any auto-tuning is necessary
 		sb.mu.Unlock()
@@ -206,12 +275,49 @@ func (sb *sensorBase) reconfigureWithConfig(ctx context.Context, deps resource.D
 		}
 		// relock the mutex after setting up the control loop since there is still a defer unlock
 		sb.mu.Lock()
+		if sb.lateral != nil {
+			sb.setupLateralControl(sb.lateral.conf)
+			if err := sb.startLateralLoop(); err != nil {
+				return err
+			}
+		}
+
+		tuning := []bool{sb.configPIDVals[0].NeedsAutoTuning(), sb.configPIDVals[1].NeedsAutoTuning()}
+		for i := range needsTuning {
+			if needsTuning[i] && !tuning[i] {
+				// report the saved gains through get_tuned_pid so they can be copied into the config
+				(*sb.tunedVals)[i] = sb.configPIDVals[i]
+			}
+		}
+		if tuning[0] || tuning[1] {
+			sb.saveGainsWhenTuned(sb.tunedVals, tuning, tuningRun)
+		}
 	}
 	sb.conf = newConf
 
 	return nil
 }
 
+// selectSensor returns the sensor to use for a role. By default the first capable sensor is used and
+// the others are kept as failovers, when sensor fusion is enabled every capable sensor is combined into a single estimate.
+func (sb *sensorBase) selectSensor(ctx context.Context, role string, candidates []movementsensor.MovementSensor,
+	conf *SCBConfig,
+) movementsensor.MovementSensor {
+	if len(candidates) == 0 {
+		return nil
+	}
+	if !conf.FuseSensors {
+		sb.logger.CInfof(ctx, "using sensor %s as %s sensor for base", candidates[0].Name().ShortName(), role)
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		return newFailoverSensor(role, candidates, sb.logger)
+	}
+	fused := newFusedSensor(role, candidates, conf.SensorWeights, sb.logger)
+	sb.logger.CInfof(ctx, "fusing sensors %v as %s sensor for base", fused.sensorNames(), role)
+	return fused
+}
+
 func (sb *sensorBase) Name() resource.Name {
 	return sb.name
 }
@@ -220,16 +326,24 @@ func (sb *sensorBase) SetPower(
 	ctx context.Context, linear, angular r3.Vector, extra map[string]interface{},
 ) error {
 	sb.opMgr.CancelRunning(ctx)
-	if sb.loop != nil {
-		sb.loop.Pause()
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	sb.stopRun()
+	sb.pauseControlLoop()
+	if err := sb.checkLevel(ctx); err != nil {
+		return err
 	}
 	return sb.controlledBase.SetPower(ctx, linear, angular, extra)
 }
 
 func (sb *sensorBase) Stop(ctx context.Context, extra map[string]interface{}) error {
 	sb.opMgr.CancelRunning(ctx)
+	sb.safety.clearUnreported()
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	sb.stopRun()
 	if sb.loop != nil {
-		sb.loop.Pause()
+		sb.pauseControlLoop()
 		// update pid controllers to be an at rest state
 		if err := sb.updateControlConfig(ctx, 0, 0); err != nil {
 			return err
@@ -253,31 +367,161 @@ func (sb *sensorBase) Geometries(ctx context.Context, extra map[string]interface
 func (sb *sensorBase) DoCommand(ctx context.Context, req map[string]interface{}) (map[string]interface{}, error) {
 	resp := make(map[string]interface{})
 
-	sb.mu.Lock()
-	defer sb.mu.Unlock()
-
 	if _, ok := req[getPID]; ok {
-		controlParams := []control.PIDConfig{}
-		for _, pidConf := range *sb.tunedVals {
-			if !pidConf.NeedsAutoTuning() {
-				controlParams = append(controlParams, pidConf)
-			}
+		resp["control_parameters"] = sb.tunedControlParams()
+		if sb.schedule != nil {
+			resp["gain_schedule"] = sb.schedule.configs()
+		}
+	}
+
+	if tuneReq, ok := req[startTuning]; ok {
+		tr, err := parseTuningRequest(tuneReq)
+		if err != nil {
+			return nil, err
+		}
+		if err := sb.startTuning(tr); err != nil {
+			return nil, err
+		}
+		resp[startTuning] = "tuning " + tuningTarget(tr)
+	}
+
+	if poseReq, ok := req[moveToPose]; ok {
+		pr, err := parsePoseRequest(poseReq)
+		if err != nil {
+			return nil, err
+		}
+		distErr, headingErr, err := sb.moveToPose(ctx, pr)
+		if err != nil {
+			return nil, err
+		}
+		resp[moveToPose] = map[string]interface{}{
+			"distance_error_mm": distErr,
+			"heading_error_deg": headingErr,
 		}
-		resp["control_parameters"] = controlParams
+	}
+
+	if ffReq, ok := req[estimateFeedforward]; ok {
+		fr, err := parseFFRequest(ffReq)
+		if err != nil {
+			return nil, err
+		}
+		estimates, err := sb.estimateFF(ctx, fr)
+		if err != nil {
+			return nil, err
+		}
+		resp[estimateFeedforward] = estimates
+	}
+
+	if dbReq, ok := req[calibrateDeadband]; ok {
+		dr, err := parseDeadbandRequest(dbReq)
+		if err != nil {
+			return nil, err
+		}
+		deadbands, err := sb.calibrateDeadbands(ctx, dr)
+		if err != nil {
+			return nil, err
+		}
+		resp[calibrateDeadband] = deadbands
+	}
+
+	if charReq, ok := req[characterize]; ok {
+		cr, err := parseCharacterizeRequest(charReq)
+		if err != nil {
+			return nil, err
+		}
+		results, err := sb.characterize(ctx, cr)
+		if err != nil {
+			return nil, err
+		}
+		resp[characterize] = results
+	}
+
+	if wpReq, ok := req[followWaypoints]; ok {
+		waypoints, err := parseWaypoints(wpReq)
+		if err != nil {
+			return nil, err
+		}
+		if err := sb.startWaypoints(ctx, waypoints); err != nil {
+			return nil, err
+		}
+		resp[followWaypoints] = fmt.Sprintf("following %d waypoints", len(waypoints))
+	}
+
+	if _, ok := req[getWaypointProgress]; ok {
+		status, err := sb.waypointStatus()
+		if err != nil {
+			return nil, err
+		}
+		resp[getWaypointProgress] = status
+	}
+
+	if historyReq, ok := req[getControlHistory]; ok {
+		start, end, err := parseHistoryWindow(historyReq)
+		if err != nil {
+			return nil, err
+		}
+		samples := []interface{}{}
+		for _, sample := range sb.history.between(start, end) {
+			samples = append(samples, sample.toMap())
+		}
+		resp[getControlHistory] = samples
+	}
+
+	if _, ok := req[getSafetyStatus]; ok {
+		resp[getSafetyStatus] = sb.safety.status()
+	}
+
+	if _, ok := req[getDiagnostics]; ok {
+		resp[getDiagnostics] = sb.diagnostics(ctx)
+	}
+
+	if _, ok := req[getStraightErr]; ok {
+		sb.mu.Lock()
+		if sb.lastStraightErrMm == nil {
+			sb.mu.Unlock()
+			return nil, errors.New("no MoveStraight has finished yet")
+		}
+		resp[getStraightErr] = map[string]interface{}{
+			"error_mm":  *sb.lastStraightErrMm,
+			"overshoot": *sb.lastStraightErrMm < 0,
+		}
+		sb.mu.Unlock()
+	}
+
+	if _, ok := req[cancelTuning]; ok {
+		if err := sb.cancelTuning(ctx); err != nil {
+			return nil, err
+		}
+		resp[cancelTuning] = "tuning stopped"
 	}
 
 	return resp, nil
 }
 
+// tunedControlParams returns the PID gains found by auto-tuning.
+func (sb *sensorBase) tunedControlParams() []control.PIDConfig {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	controlParams := []control.PIDConfig{}
+	for _, pidConf := range *sb.tunedVals {
+		if !pidConf.NeedsAutoTuning() {
+			controlParams = append(controlParams, pidConf)
+		}
+	}
+	if sb.lateral != nil && !sb.lateral.tuned.NeedsAutoTuning() {
+		controlParams = append(controlParams, sb.lateral.tuned)
+	}
+	return controlParams
+}
+
 func (sb *sensorBase) Close(ctx context.Context) error {
 	if err := sb.Stop(ctx, nil); err != nil {
 		return err
 	}
-	if sb.loop != nil {
-		sb.loop.Stop()
-		sb.loop = nil
-	}
+	sb.stopControlLoop()
 
+	sb.cancelFunc()
 	sb.activeBackgroundWorkers.Wait()
 	return nil
 }
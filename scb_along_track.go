@@ -0,0 +1,179 @@
+package controlledcomponents
+
+import (
+	"context"
+	"math"
+
+	"github.com/golang/geo/r3"
+	geo "github.com/kellydunn/golang-geo"
+	"go.viam.com/rdk/components/movementsensor"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	frameGeodetic = "geodetic"
+	frameLocal    = "local"
+	// localPositionReading is only reported by position sensors that track the base in a local frame, such as wheeled odometry
+	localPositionReading = "position_meters_X"
+	// relativePositionExtra asks a local frame sensor for its position in meters rather than as a fake geodetic point
+	relativePositionExtra = "return_relative_pos_m"
+)
+
+// selectPositionFrame returns the frame the position sensor reports in, using position_frame if it is configured.
+// Otherwise a sensor that reports its position in meters in its readings is treated as a local frame sensor.
+func (sb *sensorBase) selectPositionFrame(ctx context.Context, conf *SCBConfig) string {
+	if sb.position == nil {
+		return ""
+	}
+	if conf.PositionFrame != "" {
+		return conf.PositionFrame
+	}
+	frame := sensorPositionFrame(ctx, sb.position, conf)
+	if frame == frameLocal {
+		sb.logger.CInfof(ctx, "position sensor %s reports a local frame, measuring MoveStraight in meters",
+			sb.position.Name().ShortName())
+	}
+	return frame
+}
+
+// sensorPositionFrame returns the frame a single position sensor reports in, using sensor_position_frames
+// if it is configured for the sensor and otherwise the readings of the sensor.
+func sensorPositionFrame(ctx context.Context, ms movementsensor.MovementSensor, conf *SCBConfig) string {
+	if frame, ok := conf.SensorPositionFrames[ms.Name().ShortName()]; ok {
+		return frame
+	}
+	readings, err := ms.Readings(ctx, nil)
+	if err == nil {
+		if _, ok := readings[localPositionReading]; ok {
+			return frameLocal
+		}
+	}
+	return frameGeodetic
+}
+
+// samePositionFrame returns the position sensors that report in the same frame as position_frame, or as the first
+// sensor when it is not configured, so that fusion never averages GPS coordinates with the meters of odometry
+// and failover never switches between them.
+func (sb *sensorBase) samePositionFrame(
+	ctx context.Context, positions []movementsensor.MovementSensor, conf *SCBConfig,
+) []movementsensor.MovementSensor {
+	if len(positions) == 0 {
+		return positions
+	}
+	frame := conf.PositionFrame
+	if frame == "" {
+		frame = sensorPositionFrame(ctx, positions[0], conf)
+	}
+	var same []movementsensor.MovementSensor
+	for _, ms := range positions {
+		if msFrame := sensorPositionFrame(ctx, ms, conf); msFrame != frame {
+			sb.logger.CWarnf(ctx, "not using position sensor %s, it reports a %s frame rather than %s",
+				ms.Name().ShortName(), msFrame, frame)
+			continue
+		}
+		same = append(same, ms)
+	}
+	return same
+}
+
+// readPosition returns the position of the base in the position frame.
+// In the local frame the point holds the Y position in meters as its latitude and the X position as its longitude.
+func (sb *sensorBase) readPosition(ctx context.Context) (*geo.Point, error) {
+	var extra map[string]interface{}
+	if sb.positionFrame == frameLocal {
+		extra = map[string]interface{}{relativePositionExtra: true}
+	}
+	pos, _, err := sb.position.Position(ctx, extra)
+	if err != nil {
+		return nil, err
+	}
+	if err := sb.checkStaleReading(ctx, stalePosition, sb.position, pos.Lat(), pos.Lng()); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// displacementMm returns how far the base moved between two positions, as a vector with X pointing east
+// (or along X in the local frame) and Y pointing north (or along Y in the local frame).
+func (sb *sensorBase) displacementMm(from, to *geo.Point) r3.Vector {
+	if sb.positionFrame == frameLocal {
+		return r3.Vector{X: (to.Lng() - from.Lng()) * 1000, Y: (to.Lat() - from.Lat()) * 1000}
+	}
+	dist := from.GreatCircleDistance(to) * 1000000.
+	bearing := rdkutils.DegToRad(from.BearingTo(to))
+	return r3.Vector{X: dist * math.Sin(bearing), Y: dist * math.Cos(bearing)}
+}
+
+// alongTrack measures the progress of a MoveStraight along the course it started on,
+// so that sideways wander of the position reading is not counted as progress.
+type alongTrack struct {
+	start *geo.Point
+	// course is the unit vector the base travels along, zero until it is known
+	course r3.Vector
+	// lockDist is how far the base moves before the course is taken from its positions, half of the move,
+	// so that the noise of the position sensor is small next to the baseline the course is measured over
+	lockDist float64
+}
+
+// newAlongTrack starts tracking progress from the current position. The course is taken from a heading sensor
+// that shares the position frame when there is one, see courseHeading, flipped if the base drives backwards.
+// Otherwise it is taken from the direction the base has moved once it has covered half of the move.
+func (sb *sensorBase) newAlongTrack(ctx context.Context, distanceMm int, direction float64) (*alongTrack, error) {
+	start, err := sb.readPosition(ctx)
+	if err != nil {
+		return nil, err
+	}
+	track := &alongTrack{
+		start:    start,
+		lockDist: math.Abs(float64(distanceMm)) / 2,
+	}
+	heading, hasHeading, err := sb.courseHeading(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if hasHeading {
+		headingRad := rdkutils.DegToRad(heading)
+		track.course = r3.Vector{X: -math.Sin(headingRad), Y: math.Cos(headingRad)}.Mul(direction)
+	}
+	return track, nil
+}
+
+// courseHeading returns the heading of the base in the position frame, as a CCW yaw in degrees with 0 along
+// north or +Y, when a heading sensor shares the frame. That is the compass in the geodetic frame, or in the
+// local frame the orientation of the sensor that also reports the position, such as wheeled odometry.
+// The orientation of any other sensor has its own zero, so it cannot be used to project a course.
+func (sb *sensorBase) courseHeading(ctx context.Context) (float64, bool, error) {
+	switch {
+	case sb.positionFrame == frameGeodetic && sb.compass != nil:
+		heading, err := sb.compassYaw(ctx, sb.compass)
+		if err != nil {
+			return 0, false, err
+		}
+		return heading, true, nil
+	case sb.positionFrame == frameLocal && sb.orientation != nil && sb.orientation.Name() == sb.position.Name():
+		orient, err := sb.orientation.Orientation(ctx, nil)
+		if err != nil {
+			return 0, false, err
+		}
+		yaw := rdkutils.RadToDeg(orient.EulerAngles().Yaw)
+		if err := sb.checkStaleReading(ctx, staleHeading, sb.orientation, yaw); err != nil {
+			return 0, false, err
+		}
+		return yaw, true, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// progressMm returns how far the base has moved along its course.
+// Until the course is known the straight line distance from the start is used.
+func (sb *sensorBase) progressMm(track *alongTrack, pos *geo.Point) float64 {
+	moved := sb.displacementMm(track.start, pos)
+	if track.course == (r3.Vector{}) {
+		if moved.Norm() < track.lockDist || moved.Norm() == 0 {
+			return moved.Norm()
+		}
+		track.course = moved.Normalize()
+	}
+	return moved.Dot(track.course)
+}
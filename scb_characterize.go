@@ -0,0 +1,289 @@
+package controlledcomponents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	characterize                = "characterize"
+	defaultStepDurationSec      = 2.
+	defaultSettleBandPct        = 5.
+	characterizeSteadyStateFrac = 0.25
+)
+
+var (
+	defaultLinearStepsMmPerSec   = []float64{200, 400, 0}
+	defaultAngularStepsDegPerSec = []float64{30, 60, 0}
+)
+
+// characterizeRequest holds the options of a characterize DoCommand.
+type characterizeRequest struct {
+	axes         []string
+	linearSteps  []float64
+	angularSteps []float64
+	stepDuration time.Duration
+	// settleBand is the fraction of the step size the response has to stay within to be settled
+	settleBand float64
+}
+
+// stepSample is a velocity measured during a step, at a time in seconds since the step started.
+type stepSample struct {
+	t     float64
+	value float64
+}
+
+// parseCharacterizeRequest reads the options of a characterize DoCommand, filling in defaults for any that are missing.
+func parseCharacterizeRequest(raw interface{}) (characterizeRequest, error) {
+	cr := characterizeRequest{
+		axes:         []string{typeLinVel, typeAngVel},
+		linearSteps:  defaultLinearStepsMmPerSec,
+		angularSteps: defaultAngularStepsDegPerSec,
+		stepDuration: time.Duration(defaultStepDurationSec * float64(time.Second)),
+		settleBand:   defaultSettleBandPct / 100,
+	}
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return cr, nil
+	}
+
+	if rawAxis, ok := opts["axis"]; ok {
+		axis, _ := rawAxis.(string)
+		if axis != typeLinVel && axis != typeAngVel {
+			return characterizeRequest{}, fmt.Errorf(
+				"characterize axis '%v' not accepted, axis must be 'linear_velocity' or 'angular_velocity'", rawAxis)
+		}
+		cr.axes = []string{axis}
+	}
+	for name, steps := range map[string]*[]float64{
+		"linear_steps_mm_per_sec":    &cr.linearSteps,
+		"angular_steps_degs_per_sec": &cr.angularSteps,
+	} {
+		raw, ok := opts[name]
+		if !ok {
+			continue
+		}
+		list, ok := raw.([]interface{})
+		if !ok || len(list) == 0 {
+			return characterizeRequest{}, fmt.Errorf("characterize %s must be a list of numbers", name)
+		}
+		parsed := make([]float64, 0, len(list))
+		for _, v := range list {
+			step, ok := v.(float64)
+			if !ok {
+				return characterizeRequest{}, fmt.Errorf("characterize %s must be a list of numbers", name)
+			}
+			parsed = append(parsed, step)
+		}
+		*steps = parsed
+	}
+	if duration, ok := opts["step_duration_sec"].(float64); ok {
+		if duration <= 0 {
+			return characterizeRequest{}, errors.New("characterize step_duration_sec must be greater than 0")
+		}
+		cr.stepDuration = time.Duration(duration * float64(time.Second))
+	}
+	if band, ok := opts["settle_band_pct"].(float64); ok {
+		if band <= 0 || band >= 100 {
+			return characterizeRequest{}, errors.New("characterize settle_band_pct must be between 0 and 100")
+		}
+		cr.settleBand = band / 100
+	}
+	return cr, nil
+}
+
+// characterize steps the velocity setpoint of each requested axis through the control loop, holding the other
+// axis at 0, and measures how the base responds to each step. The base is stopped when it finishes.
+// Linear results are in mm/s and angular results in deg/s. A Stop or another command cancelling the
+// characterization is not an error, the axes that finished are returned and marked as stopped.
+func (sb *sensorBase) characterize(ctx context.Context, cr characterizeRequest) (map[string]interface{}, error) {
+	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	ctx, done := sb.newOperation(ctx)
+	defer done()
+
+	if err := sb.checkLevel(ctx); err != nil {
+		return nil, err
+	}
+
+	if sb.controlLoopConfig == nil {
+		return nil, errors.New("characterize requires a velocity sensor and control_parameters to be configured")
+	}
+	if err := sb.checkTuningStatus(); err != nil {
+		return nil, err
+	}
+	if sb.loop == nil {
+		if err := sb.startControlLoop(); err != nil {
+			return nil, err
+		}
+	}
+	sb.resetControlLoop()
+
+	run := sb.startRun(ctx, characterize, map[string]interface{}{
+		"axes": cr.axes, "step_duration_sec": cr.stepDuration.Seconds(), "settle_band_pct": cr.settleBand * 100,
+	})
+	defer sb.endRun(run)
+
+	results := map[string]interface{}{}
+	for _, axis := range cr.axes {
+		result, err := sb.characterizeAxis(ctx, cr, axis)
+		// the base is left to whatever cancelled the characterization, a safety fault is still returned
+		var fault *SafetyStopError
+		if err != nil && !errors.As(err, &fault) && errors.Is(err, context.Canceled) {
+			sb.logger.CInfof(ctx, "characterize stopped before the %s steps finished", axis)
+			results["stopped"] = true
+			return results, nil
+		}
+		if err != nil {
+			// leave the base stopped rather than driving on at the last step
+			if stopErr := sb.Stop(ctx, nil); stopErr != nil {
+				sb.logger.CError(ctx, stopErr)
+			}
+			return nil, err
+		}
+		results[axis] = result
+	}
+	return results, sb.Stop(ctx, nil)
+}
+
+// characterizeAxis runs the steps of one axis and returns the metrics of each step,
+// along with the RMS error of the whole axis and its largest overshoot.
+func (sb *sensorBase) characterizeAxis(ctx context.Context, cr characterizeRequest, axis string) (map[string]interface{}, error) {
+	steps := cr.linearSteps
+	if axis == typeAngVel {
+		steps = cr.angularSteps
+	}
+	ticker := time.NewTicker(time.Duration(1000./sb.controlLoopConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+
+	stepResults := make([]interface{}, 0, len(steps))
+	var sumSq, maxOvershoot float64
+	var count int
+	initial := 0.
+	for _, target := range steps {
+		var err error
+		if axis == typeLinVel {
+			err = sb.updateControlConfig(ctx, target/1000.0, 0)
+		} else {
+			err = sb.updateControlConfig(ctx, 0, target)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var samples []stepSample
+		stepStart := time.Now()
+		for time.Since(stepStart) < cr.stepDuration {
+			select {
+			case <-ctx.Done():
+				return nil, context.Cause(ctx)
+			case <-ticker.C:
+			}
+			value, err := sb.measuredVelocity(ctx, axis)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, stepSample{t: time.Since(stepStart).Seconds(), value: value})
+			sumSq += (target - value) * (target - value)
+			count++
+		}
+
+		result := stepMetrics(samples, initial, target, cr.settleBand)
+		if overshoot, ok := result["overshoot_pct"].(float64); ok {
+			maxOvershoot = math.Max(maxOvershoot, overshoot)
+		}
+		stepResults = append(stepResults, result)
+		initial = target
+	}
+
+	axisResult := map[string]interface{}{"steps": stepResults, "max_overshoot_pct": maxOvershoot}
+	if count > 0 {
+		axisResult["rms_error"] = math.Sqrt(sumSq / float64(count))
+	}
+	return axisResult, nil
+}
+
+// measuredVelocity returns the velocity of an axis, linear in mm/s and angular in deg/s.
+func (sb *sensorBase) measuredVelocity(ctx context.Context, axis string) (float64, error) {
+	if axis == typeLinVel {
+		linvel, err := sb.velocities.LinearVelocity(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		return linvel.Y * 1000, nil
+	}
+	angvel, err := sb.velocities.AngularVelocity(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	return angvel.Z, nil
+}
+
+// stepMetrics measures the response to a step of the setpoint from initial to target.
+// The rise time is the time taken to go from 10% to 90% of the step, the overshoot is how far the response
+// went past the target as a percentage of the step, and the settling time is when the response last entered
+// the settle band around the target. The steady state error is the mean error over the last quarter of the step,
+// and the RMS error is taken over the whole step. Metrics that do not apply, such as the rise time
+// of a response that never reached 90% of the step, are left out.
+func stepMetrics(samples []stepSample, initial, target, settleBand float64) map[string]interface{} {
+	result := map[string]interface{}{"setpoint": target}
+	if len(samples) == 0 {
+		return result
+	}
+
+	var sumSq, sumSteady float64
+	steadyStart := int(float64(len(samples)) * (1 - characterizeSteadyStateFrac))
+	if steadyStart >= len(samples) {
+		steadyStart = len(samples) - 1
+	}
+	for i, s := range samples {
+		sumSq += (target - s.value) * (target - s.value)
+		if i >= steadyStart {
+			sumSteady += target - s.value
+		}
+	}
+	result["rms_error"] = math.Sqrt(sumSq / float64(len(samples)))
+	result["steady_state_error"] = sumSteady / float64(len(samples)-steadyStart)
+
+	step := target - initial
+	if step == 0 {
+		return result
+	}
+
+	// progress is the fraction of the step the response has covered
+	t10, t90 := -1., -1.
+	maxProgress := math.Inf(-1)
+	lastOutside := -1
+	for i, s := range samples {
+		progress := (s.value - initial) / step
+		if t10 < 0 && progress >= 0.1 {
+			t10 = s.t
+		}
+		if t90 < 0 && progress >= 0.9 {
+			t90 = s.t
+		}
+		maxProgress = math.Max(maxProgress, progress)
+		if math.Abs(target-s.value) > settleBand*math.Abs(step) {
+			lastOutside = i
+		}
+	}
+	if t10 >= 0 && t90 >= 0 {
+		result["rise_time_sec"] = t90 - t10
+	}
+	result["overshoot_pct"] = math.Max(0, (maxProgress-1)*100)
+
+	settled := lastOutside < len(samples)-1
+	result["settled"] = settled
+	if settled {
+		settlingTime := 0.
+		if lastOutside >= 0 {
+			settlingTime = samples[lastOutside+1].t
+		}
+		result["settling_time_sec"] = settlingTime
+	}
+	return result
+}
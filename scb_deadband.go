@@ -0,0 +1,191 @@
+package controlledcomponents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/utils"
+)
+
+const (
+	calibrateDeadband = "calibrate_deadband"
+	// the defaults of a calibrate_deadband DoCommand
+	defaultDeadbandMaxPower        = 0.5
+	defaultDeadbandRampSec         = 5.
+	defaultLinearMotionMmPerSec    = 10.
+	defaultAngularMotionDegsPerSec = 2.
+	// deadbandMotionTicks is how many ticks in a row the base has to be measured moving for the motion to count,
+	// so that a single noisy reading does not end the ramp
+	deadbandMotionTicks = 2
+)
+
+// deadbandRequest holds the options of a calibrate_deadband DoCommand.
+type deadbandRequest struct {
+	axes     []string
+	maxPower float64
+	ramp     time.Duration
+	// thresholds are the speeds above which the base is moving, in mm/s and deg/s
+	linearThreshold  float64
+	angularThreshold float64
+}
+
+// applyDeadband maps a non-zero power of an axis past the deadband of its motors, so that the smallest power
+// the controller asks for already moves the base and full power stays full power.
+// Axes with a setpoint of 0 are left alone, so that a stopped base does not twitch.
+func applyDeadband(power, deadband, setpoint float64) float64 {
+	if deadband == 0 || power == 0 || setpoint == 0 {
+		return power
+	}
+	return sign(power) * (deadband + (1-deadband)*math.Abs(power))
+}
+
+// parseDeadbandRequest reads the options of a calibrate_deadband DoCommand, filling in defaults for any that are missing.
+func parseDeadbandRequest(raw interface{}) (deadbandRequest, error) {
+	dr := deadbandRequest{
+		axes:             []string{typeLinVel, typeAngVel},
+		maxPower:         defaultDeadbandMaxPower,
+		ramp:             time.Duration(defaultDeadbandRampSec * float64(time.Second)),
+		linearThreshold:  defaultLinearMotionMmPerSec,
+		angularThreshold: defaultAngularMotionDegsPerSec,
+	}
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return dr, nil
+	}
+	if rawAxis, ok := opts["axis"]; ok {
+		axis, _ := rawAxis.(string)
+		if axis != typeLinVel && axis != typeAngVel {
+			return deadbandRequest{}, fmt.Errorf(
+				"calibrate_deadband axis '%v' not accepted, axis must be 'linear_velocity' or 'angular_velocity'", rawAxis)
+		}
+		dr.axes = []string{axis}
+	}
+	if maxPower, ok := opts["max_power"].(float64); ok {
+		if maxPower <= 0 || maxPower > 1 {
+			return deadbandRequest{}, errors.New("calibrate_deadband max_power must be between 0 and 1")
+		}
+		dr.maxPower = maxPower
+	}
+	if secs, ok := opts["ramp_sec"].(float64); ok {
+		if secs <= 0 {
+			return deadbandRequest{}, errors.New("calibrate_deadband ramp_sec must be greater than 0")
+		}
+		dr.ramp = time.Duration(secs * float64(time.Second))
+	}
+	for name, threshold := range map[string]*float64{
+		"linear_threshold_mm_per_sec":    &dr.linearThreshold,
+		"angular_threshold_degs_per_sec": &dr.angularThreshold,
+	} {
+		if v, ok := opts[name].(float64); ok {
+			if v <= 0 {
+				return deadbandRequest{}, fmt.Errorf("calibrate_deadband %s must be greater than 0", name)
+			}
+			*threshold = v
+		}
+	}
+	return dr, nil
+}
+
+// calibrateDeadbands ramps the power of each requested axis open loop until the velocity sensor shows the base moving,
+// and uses the power it started moving at as the deadband of the axis. The base is stopped when it finishes.
+// The deadbands are only used until the next reconfigure, so they are returned to be copied into the config.
+func (sb *sensorBase) calibrateDeadbands(ctx context.Context, dr deadbandRequest) ([]interface{}, error) {
+	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	sb.stopRun()
+	ctx, done := sb.newOperation(ctx)
+	defer done()
+
+	if err := sb.checkLevel(ctx); err != nil {
+		return nil, err
+	}
+
+	if sb.velocities == nil {
+		return nil, errors.New("calibrate_deadband requires a velocity sensor")
+	}
+	sb.pauseControlLoop()
+	// always leave the base stopped, even if the calibration was cancelled
+	defer func() {
+		if err := sb.controlledBase.Stop(context.Background(), nil); err != nil {
+			sb.logger.Error(err)
+		}
+	}()
+
+	deadbands := make([]interface{}, 0, len(dr.axes))
+	for _, axis := range dr.axes {
+		deadband, err := sb.calibrateAxisDeadband(ctx, dr, axis)
+		if err != nil {
+			return nil, err
+		}
+		sb.logger.CInfof(ctx, "calibrated %s deadband to %.3f", axis, deadband)
+		sb.mu.Lock()
+		if axis == typeLinVel {
+			sb.deadband[0] = deadband
+		} else {
+			sb.deadband[1] = deadband
+		}
+		sb.mu.Unlock()
+		deadbands = append(deadbands, map[string]interface{}{"type": axis, "power": deadband})
+	}
+	sb.logger.CInfof(ctx, "calibrated deadbands are not saved, copy %v into the deadband attribute to keep them", deadbands)
+	return deadbands, nil
+}
+
+// calibrateAxisDeadband ramps the power of one axis until the base moves, then stops the base and waits for it to settle.
+func (sb *sensorBase) calibrateAxisDeadband(ctx context.Context, dr deadbandRequest, axis string) (float64, error) {
+	threshold := dr.linearThreshold
+	if axis == typeAngVel {
+		threshold = dr.angularThreshold
+	}
+	ticker := time.NewTicker(time.Duration(1000./sb.controlFreq) * time.Millisecond)
+	defer ticker.Stop()
+
+	var moving int
+	var firstMoving float64
+	start := time.Now()
+	for time.Since(start) < dr.ramp {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+		power := dr.maxPower * time.Since(start).Seconds() / dr.ramp.Seconds()
+		var err error
+		if axis == typeLinVel {
+			err = sb.controlledBase.SetPower(ctx, r3.Vector{Y: power}, r3.Vector{}, nil)
+		} else {
+			err = sb.controlledBase.SetPower(ctx, r3.Vector{}, r3.Vector{Z: power}, nil)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		vel, err := sb.measuredVelocity(ctx, axis)
+		if err != nil {
+			return 0, err
+		}
+		if math.Abs(vel) < threshold {
+			moving = 0
+			continue
+		}
+		if moving == 0 {
+			firstMoving = power
+		}
+		moving++
+		if moving >= deadbandMotionTicks {
+			if err := sb.controlledBase.Stop(ctx, nil); err != nil {
+				return 0, err
+			}
+			if !utils.SelectContextOrWait(ctx, ffSettleTime) {
+				return 0, ctx.Err()
+			}
+			return firstMoving, nil
+		}
+	}
+	return 0, fmt.Errorf("the base did not start moving on %s before reaching max_power %v", axis, dr.maxPower)
+}
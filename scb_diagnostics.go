@@ -0,0 +1,121 @@
+package controlledcomponents
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.viam.com/rdk/components/movementsensor"
+)
+
+const (
+	// diagnosticsTimingSamples is how many of the latest control loop ticks the loop timing is measured over
+	diagnosticsTimingSamples = 20
+
+	tuningNotConfigured = "not_configured"
+	tuningRunning       = "tuning"
+	tuningNeeded        = "needs_tuning"
+	tuningReady         = "ready"
+	operationIdle       = "idle"
+)
+
+// diagnostics returns the state of the controller as a flat map, so that it can be returned by
+// the Readings of a control-diagnostics sensor and captured as a table.
+func (sb *sensorBase) diagnostics(ctx context.Context) map[string]interface{} {
+	current, recent := sb.history.snapshot(diagnosticsTimingSamples)
+	operation := sb.history.runKind()
+	if operation == "" {
+		operation = operationIdle
+	}
+	status, statusMsg := sb.tuningStatus()
+
+	saturations := sb.saturation.counts()
+
+	sb.mu.Lock()
+	roles := map[string]movementsensor.MovementSensor{
+		"velocity_sensor":    sb.velocities,
+		"orientation_sensor": sb.orientation,
+		"position_sensor":    sb.position,
+		"compass_sensor":     sb.compass,
+	}
+	loopRunning := sb.loop != nil && sb.loop.Running()
+	diag := map[string]interface{}{
+		"linear_setpoint_m_per_s":    current.linearSetpoint,
+		"angular_setpoint_deg_per_s": current.angularSetpoint,
+		"linear_measured_m_per_s":    current.linearMeasured,
+		"angular_measured_deg_per_s": current.angularMeasured,
+		"position_error_mm":          current.positionErrMm,
+		"active_operation":           operation,
+		"tuning_status":              status,
+		"control_frequency_hz":       sb.controlFreq,
+		"loop_running":               loopRunning,
+		"linear_saturation_events":   saturations[0],
+		"angular_saturation_events":  saturations[1],
+	}
+	if sb.lateral != nil {
+		diag["lateral_saturation_events"] = saturations[latAxis]
+	}
+	sb.mu.Unlock()
+
+	for role, ms := range roles {
+		diag[role] = sensorRoleName(ms)
+	}
+	if statusMsg != "" {
+		diag["tuning_message"] = statusMsg
+	}
+	if len(recent) != 0 {
+		latest := recent[len(recent)-1]
+		diag["linear_output"] = latest.linearOutput
+		diag["angular_output"] = latest.angularOutput
+		diag["last_tick_age_ms"] = float64(time.Since(latest.time)) / float64(time.Millisecond)
+	}
+	// the period is only meaningful while the loop is ticking, a paused loop leaves a gap between samples
+	if len(recent) > 1 && loopRunning {
+		var total, longest time.Duration
+		for i := 1; i < len(recent); i++ {
+			period := recent[i].time.Sub(recent[i-1].time)
+			total += period
+			longest = max(longest, period)
+		}
+		diag["loop_period_ms"] = float64(total) / float64(len(recent)-1) / float64(time.Millisecond)
+		diag["loop_period_max_ms"] = float64(longest) / float64(time.Millisecond)
+	}
+
+	// the heading is the one last sampled by a motion, reading the sensors here would count towards their staleness
+	if heading, at := sb.history.lastHeading(); !at.IsZero() {
+		diag["heading_deg"] = heading
+		diag["heading_age_ms"] = float64(time.Since(at)) / float64(time.Millisecond)
+	}
+	return diag
+}
+
+// tuningStatus returns whether the base can be commanded, along with the reason if it cannot.
+func (sb *sensorBase) tuningStatus() (string, string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.controlLoopConfig == nil {
+		return tuningNotConfigured, ""
+	}
+	if sb.tuneCancel != nil || sb.tuningInProgress() {
+		return tuningRunning, ""
+	}
+	if err := sb.checkTuningStatus(); err != nil {
+		return tuningNeeded, err.Error()
+	}
+	return tuningReady, ""
+}
+
+// sensorRoleName returns the names of the physical sensors filling a role. A failover role reports the
+// sensor that last provided a reading, and a fused role reports every fused sensor separated by commas.
+func sensorRoleName(ms movementsensor.MovementSensor) string {
+	switch s := ms.(type) {
+	case nil:
+		return ""
+	case *failoverSensor:
+		return s.activeName()
+	case *fusedSensor:
+		return strings.Join(s.sensorNames(), ",")
+	default:
+		return ms.Name().ShortName()
+	}
+}
@@ -0,0 +1,255 @@
+package controlledcomponents
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r3"
+	geo "github.com/kellydunn/golang-geo"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/movementsensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/spatialmath"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+// sensorRetryInterval is how long a failing sensor is ranked behind the healthy sensors of its role
+// before it is tried first again.
+const sensorRetryInterval = time.Second
+
+// sensorHealth tracks which sensors of a role are failing, so that reads can rank them behind healthy
+// sensors and so that a sensor dropping out or recovering is logged once rather than on every read.
+type sensorHealth struct {
+	mu       sync.Mutex
+	role     string
+	logger   logging.Logger
+	sensors  []movementsensor.MovementSensor
+	failedAt []time.Time
+	failing  []bool
+}
+
+func newSensorHealth(role string, sensors []movementsensor.MovementSensor, logger logging.Logger) *sensorHealth {
+	return &sensorHealth{
+		role:     role,
+		logger:   logger,
+		sensors:  sensors,
+		failedAt: make([]time.Time, len(sensors)),
+		failing:  make([]bool, len(sensors)),
+	}
+}
+
+// readOrder returns the sensor indices in configured order, with any sensor that failed within
+// sensorRetryInterval moved behind the others.
+func (h *sensorHealth) readOrder() []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	order := make([]int, 0, len(h.sensors))
+	var retry []int
+	for i := range h.sensors {
+		if h.failing[i] && time.Since(h.failedAt[i]) < sensorRetryInterval {
+			retry = append(retry, i)
+			continue
+		}
+		order = append(order, i)
+	}
+	return append(order, retry...)
+}
+
+func (h *sensorHealth) markFailed(ctx context.Context, i int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.failing[i] {
+		h.logger.CWarnf(ctx, "%s sensor %s failed: %v", h.role, h.sensors[i].Name().ShortName(), err)
+	}
+	h.failing[i] = true
+	h.failedAt[i] = time.Now()
+}
+
+func (h *sensorHealth) markHealthy(ctx context.Context, i int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failing[i] {
+		h.logger.CInfof(ctx, "%s sensor %s recovered", h.role, h.sensors[i].Name().ShortName())
+	}
+	h.failing[i] = false
+}
+
+// failoverSensor reads a role from the first healthy sensor in the configured order.
+// When the active sensor errors it switches to the next capable sensor, and it switches back
+// once a higher ranked sensor recovers. It embeds the first sensor, so Name behaves like that sensor.
+// Orientations, compass headings and local positions are aligned across a switch, see readingAligner.
+type failoverSensor struct {
+	movementsensor.MovementSensor
+	*sensorHealth
+	active int
+
+	orientations *readingAligner
+	headings     *readingAligner
+	// positions is only set for sensors that report in a local frame, geodetic positions share one zero
+	positions *readingAligner
+}
+
+func newFailoverSensor(role string, sensors []movementsensor.MovementSensor, logger logging.Logger) *failoverSensor {
+	return &failoverSensor{
+		MovementSensor: sensors[0],
+		sensorHealth:   newSensorHealth(role, sensors, logger),
+		orientations:   newReadingAligner(len(sensors), true),
+		headings:       newReadingAligner(len(sensors), true),
+	}
+}
+
+// readingAligner keeps the readings of a failover role continuous when it switches sensors. Sensors such as
+// an IMU and wheeled odometry measure from different zeros, so without it a motion steering by the reading
+// would see the difference between the zeros as a sudden error. When a sensor takes over, its offset from
+// the last reading returned is measured and added to its readings until the role switches again.
+type readingAligner struct {
+	mu      sync.Mutex
+	angular bool
+	// source is the sensor the last reading came from, -1 until a reading has been returned
+	source  int
+	last    []float64
+	offsets [][]float64
+}
+
+// newReadingAligner returns an aligner for n sensors. Angular readings are in degrees and are compared
+// the short way around the circle.
+func newReadingAligner(n int, angular bool) *readingAligner {
+	return &readingAligner{angular: angular, source: -1, offsets: make([][]float64, n)}
+}
+
+// align returns the values read from sensor i moved onto the zero of the readings returned before it.
+func (a *readingAligner) align(i int, values []float64) []float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.offsets[i] == nil {
+		a.offsets[i] = make([]float64, len(values))
+	}
+	if a.source >= 0 && a.source != i {
+		for k, v := range values {
+			a.offsets[i][k] = a.last[k] - v
+			if a.angular {
+				a.offsets[i][k] = math.Remainder(a.offsets[i][k], oneTurn)
+			}
+		}
+	}
+	aligned := make([]float64, len(values))
+	for k, v := range values {
+		aligned[k] = v + a.offsets[i][k]
+	}
+	a.source = i
+	a.last = aligned
+	return aligned
+}
+
+// read calls f on each sensor in rank order until one succeeds, and returns the index of that sensor.
+func (fs *failoverSensor) read(ctx context.Context, f func(ms movementsensor.MovementSensor) error) (int, error) {
+	var lastErr error
+	for _, i := range fs.readOrder() {
+		if err := f(fs.sensors[i]); err != nil {
+			fs.markFailed(ctx, i, err)
+			lastErr = err
+			continue
+		}
+		fs.markHealthy(ctx, i)
+		fs.setActive(ctx, i)
+		return i, nil
+	}
+	return 0, errors.Wrapf(lastErr, "all %s sensors failed", fs.role)
+}
+
+func (fs *failoverSensor) setActive(ctx context.Context, i int) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.active != i {
+		fs.logger.CInfof(ctx, "switching %s sensor from %s to %s",
+			fs.role, fs.sensors[fs.active].Name().ShortName(), fs.sensors[i].Name().ShortName())
+		fs.active = i
+	}
+}
+
+// activeName returns the short name of the sensor that last provided a reading.
+func (fs *failoverSensor) activeName() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.sensors[fs.active].Name().ShortName()
+}
+
+func (fs *failoverSensor) LinearVelocity(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+	var vel r3.Vector
+	_, err := fs.read(ctx, func(ms movementsensor.MovementSensor) error {
+		var err error
+		vel, err = ms.LinearVelocity(ctx, extra)
+		return err
+	})
+	return vel, err
+}
+
+func (fs *failoverSensor) AngularVelocity(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+	var vel spatialmath.AngularVelocity
+	_, err := fs.read(ctx, func(ms movementsensor.MovementSensor) error {
+		var err error
+		vel, err = ms.AngularVelocity(ctx, extra)
+		return err
+	})
+	return vel, err
+}
+
+func (fs *failoverSensor) Position(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+	var pos *geo.Point
+	var alt float64
+	i, err := fs.read(ctx, func(ms movementsensor.MovementSensor) error {
+		var err error
+		pos, alt, err = ms.Position(ctx, extra)
+		return err
+	})
+	if err != nil || fs.positions == nil {
+		return pos, alt, err
+	}
+	// local positions hold meters as their latitude and longitude, see readPosition
+	aligned := fs.positions.align(i, []float64{pos.Lat(), pos.Lng()})
+	return geo.NewPoint(aligned[0], aligned[1]), alt, nil
+}
+
+// alignLocalPositions aligns the positions of the sensors across a switch, for sensors that report
+// in a local frame such as wheeled odometry, where each sensor starts from its own origin.
+func (fs *failoverSensor) alignLocalPositions() {
+	fs.positions = newReadingAligner(len(fs.sensors), false)
+}
+
+func (fs *failoverSensor) Orientation(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+	var orient spatialmath.Orientation
+	i, err := fs.read(ctx, func(ms movementsensor.MovementSensor) error {
+		var err error
+		orient, err = ms.Orientation(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	euler := orient.EulerAngles()
+	angles := fs.orientations.align(i, []float64{
+		rdkutils.RadToDeg(euler.Roll), rdkutils.RadToDeg(euler.Pitch), rdkutils.RadToDeg(euler.Yaw),
+	})
+	return &spatialmath.EulerAngles{
+		Roll:  rdkutils.DegToRad(math.Remainder(angles[0], oneTurn)),
+		Pitch: rdkutils.DegToRad(math.Remainder(angles[1], oneTurn)),
+		Yaw:   rdkutils.DegToRad(math.Remainder(angles[2], oneTurn)),
+	}, nil
+}
+
+func (fs *failoverSensor) CompassHeading(ctx context.Context, extra map[string]interface{}) (float64, error) {
+	var heading float64
+	i, err := fs.read(ctx, func(ms movementsensor.MovementSensor) error {
+		var err error
+		heading, err = ms.CompassHeading(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	// compass headings are reported between [0, 360)
+	heading = math.Mod(fs.headings.align(i, []float64{heading})[0]+oneTurn, oneTurn)
+	return heading, nil
+}
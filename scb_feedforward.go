@@ -0,0 +1,323 @@
+package controlledcomponents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/utils"
+)
+
+const (
+	estimateFeedforward = "estimate_feedforward"
+	defaultFFMaxPower   = 0.5
+	defaultFFRampSec    = 5.
+	defaultFFStepSec    = 1.
+	// ffSettleTime is how long the base is left to stop between the phases of the ramp test
+	ffSettleTime = 500 * time.Millisecond
+	// ffMaxTickGap is the longest time between ticks that setpoint changes are treated as an acceleration,
+	// longer gaps mean the loop was paused
+	ffMaxTickGap = time.Second
+	// ffMovingFrac is the fraction of the fastest velocity of a ramp test above which the base is treated as moving
+	ffMovingFrac = 0.05
+	// ffAccelFrac is the fraction of the largest acceleration of a step test above which it is used to fit kA
+	ffAccelFrac = 0.1
+)
+
+// feedforward adds the power a base needs to follow its setpoints to the output of the PIDs, so that
+// the integrators only have to correct what the model misses. Each axis uses
+// kV * setpoint + kA * the rate of change of the setpoint + kS in the direction of the setpoint.
+// A nil feedforward adds nothing.
+type feedforward struct {
+	mu sync.Mutex
+	// gains and setpoints are indexed like configPIDVals, linear then angular
+	gains     [2]FeedforwardConfig
+	setpoints [2]float64
+	// prev and prevTime are the setpoints of the previous tick, used to find the acceleration the setpoints ask for
+	prev     [2]float64
+	prevTime time.Time
+}
+
+// newFeedforward returns the feedforward of the configured axes, or nil if none are configured.
+func newFeedforward(confs []FeedforwardConfig) *feedforward {
+	if len(confs) == 0 {
+		return nil
+	}
+	ff := &feedforward{}
+	for _, conf := range confs {
+		if conf.Type == typeLinVel {
+			ff.gains[0] = conf
+		} else {
+			ff.gains[1] = conf
+		}
+	}
+	return ff
+}
+
+// setSetpoint records the setpoint of an axis, linear in m/s and angular in deg/s.
+func (ff *feedforward) setSetpoint(axis int, setpoint float64) {
+	if ff == nil {
+		return
+	}
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	ff.setpoints[axis] = setpoint
+}
+
+// output returns the feedforward power of the linear and angular axes for a tick of the control loop at now.
+func (ff *feedforward) output(now time.Time) (float64, float64) {
+	if ff == nil {
+		return 0, 0
+	}
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	dt := now.Sub(ff.prevTime)
+	var out [2]float64
+	for i, g := range ff.gains {
+		setpoint := ff.setpoints[i]
+		out[i] = g.KV * setpoint
+		if setpoint != 0 {
+			out[i] += g.KS * sign(setpoint)
+		}
+		if !ff.prevTime.IsZero() && dt > 0 && dt <= ffMaxTickGap {
+			out[i] += g.KA * (setpoint - ff.prev[i]) / dt.Seconds()
+		}
+	}
+	ff.prev = ff.setpoints
+	ff.prevTime = now
+	return out[0], out[1]
+}
+
+// power returns the power the feedforward gains of an axis predict for it to hold a speed,
+// and false if the axis has no kV to predict it with.
+func (ff *feedforward) power(axis int, speed float64) (float64, bool) {
+	if ff == nil {
+		return 0, false
+	}
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	g := ff.gains[axis]
+	if g.KV == 0 {
+		return 0, false
+	}
+	return g.KS + g.KV*speed, true
+}
+
+// ffRequest holds the options of an estimate_feedforward DoCommand.
+type ffRequest struct {
+	axes     []string
+	maxPower float64
+	ramp     time.Duration
+	step     time.Duration
+}
+
+// ffSample is the power sent to the base and the speed and acceleration it measured at one tick of a ramp test.
+type ffSample struct {
+	power, vel, accel float64
+}
+
+// parseFFRequest reads the options of an estimate_feedforward DoCommand, filling in defaults for any that are missing.
+func parseFFRequest(raw interface{}) (ffRequest, error) {
+	fr := ffRequest{
+		axes:     []string{typeLinVel, typeAngVel},
+		maxPower: defaultFFMaxPower,
+		ramp:     time.Duration(defaultFFRampSec * float64(time.Second)),
+		step:     time.Duration(defaultFFStepSec * float64(time.Second)),
+	}
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return fr, nil
+	}
+	if rawAxis, ok := opts["axis"]; ok {
+		axis, _ := rawAxis.(string)
+		if axis != typeLinVel && axis != typeAngVel {
+			return ffRequest{}, fmt.Errorf(
+				"estimate_feedforward axis '%v' not accepted, axis must be 'linear_velocity' or 'angular_velocity'", rawAxis)
+		}
+		fr.axes = []string{axis}
+	}
+	if maxPower, ok := opts["max_power"].(float64); ok {
+		if maxPower <= 0 || maxPower > 1 {
+			return ffRequest{}, errors.New("estimate_feedforward max_power must be between 0 and 1")
+		}
+		fr.maxPower = maxPower
+	}
+	for name, d := range map[string]*time.Duration{"ramp_sec": &fr.ramp, "step_sec": &fr.step} {
+		if secs, ok := opts[name].(float64); ok {
+			if secs <= 0 {
+				return ffRequest{}, fmt.Errorf("estimate_feedforward %s must be greater than 0", name)
+			}
+			*d = time.Duration(secs * float64(time.Second))
+		}
+	}
+	return fr, nil
+}
+
+// estimateFF drives each requested axis open loop to estimate its feedforward gains, and stops the base when it finishes.
+// A slow ramp of the power up to max_power finds kS and kV, as the base barely accelerates,
+// then a step to max_power finds kA from the power that kS and kV do not account for.
+func (sb *sensorBase) estimateFF(ctx context.Context, fr ffRequest) ([]interface{}, error) {
+	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	sb.stopRun()
+	ctx, done := sb.newOperation(ctx)
+	defer done()
+
+	if err := sb.checkLevel(ctx); err != nil {
+		return nil, err
+	}
+
+	if sb.velocities == nil {
+		return nil, errors.New("estimate_feedforward requires a velocity sensor")
+	}
+	sb.pauseControlLoop()
+	// always leave the base stopped, even if the test was cancelled
+	defer func() {
+		if err := sb.controlledBase.Stop(context.Background(), nil); err != nil {
+			sb.logger.Error(err)
+		}
+	}()
+
+	estimates := make([]interface{}, 0, len(fr.axes))
+	for _, axis := range fr.axes {
+		conf, err := sb.estimateAxisFF(ctx, fr, axis)
+		if err != nil {
+			return nil, err
+		}
+		sb.logger.CInfof(ctx, "estimated %s feedforward as %+v", axis, conf)
+		estimates = append(estimates, map[string]interface{}{"type": axis, "kv": conf.KV, "ka": conf.KA, "ks": conf.KS})
+	}
+	return estimates, nil
+}
+
+// estimateAxisFF runs the ramp and step of one axis and fits its feedforward gains.
+func (sb *sensorBase) estimateAxisFF(ctx context.Context, fr ffRequest, axis string) (FeedforwardConfig, error) {
+	ramp, err := sb.runFFTest(ctx, axis, fr.ramp, func(elapsed time.Duration) float64 {
+		return fr.maxPower * elapsed.Seconds() / fr.ramp.Seconds()
+	})
+	if err != nil {
+		return FeedforwardConfig{}, err
+	}
+	ks, kv, err := fitStaticAndVelocity(ramp)
+	if err != nil {
+		return FeedforwardConfig{}, fmt.Errorf("could not estimate %s feedforward: %w", axis, err)
+	}
+
+	step, err := sb.runFFTest(ctx, axis, fr.step, func(time.Duration) float64 { return fr.maxPower })
+	if err != nil {
+		return FeedforwardConfig{}, err
+	}
+	return FeedforwardConfig{Type: axis, KS: ks, KV: kv, KA: fitAcceleration(step, ks, kv)}, nil
+}
+
+// runFFTest sends the power returned by powerAt to one axis of the base every tick for the duration,
+// then stops the base and waits for it to settle. It returns the power and measured motion of each tick.
+func (sb *sensorBase) runFFTest(ctx context.Context, axis string, duration time.Duration,
+	powerAt func(elapsed time.Duration) float64,
+) ([]ffSample, error) {
+	ticker := time.NewTicker(time.Duration(1000./sb.controlFreq) * time.Millisecond)
+	defer ticker.Stop()
+
+	var samples []ffSample
+	var prevVel float64
+	var prevTime time.Time
+	start := time.Now()
+	for time.Since(start) < duration {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+		power := powerAt(time.Since(start))
+		var err error
+		if axis == typeLinVel {
+			err = sb.controlledBase.SetPower(ctx, r3.Vector{Y: power}, r3.Vector{}, nil)
+		} else {
+			err = sb.controlledBase.SetPower(ctx, r3.Vector{}, r3.Vector{Z: power}, nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		vel, err := sb.measuredVelocity(ctx, axis)
+		if err != nil {
+			return nil, err
+		}
+		if axis == typeLinVel {
+			// the linear gains map setpoints in m/s
+			vel /= 1000
+		}
+		// the fits use speeds, so that a sensor that reports the axis with the opposite sign still works
+		vel = math.Abs(vel)
+		now := time.Now()
+		sample := ffSample{power: power, vel: vel}
+		if !prevTime.IsZero() {
+			sample.accel = (vel - prevVel) / now.Sub(prevTime).Seconds()
+		}
+		samples = append(samples, sample)
+		prevVel, prevTime = vel, now
+	}
+
+	if err := sb.controlledBase.Stop(ctx, nil); err != nil {
+		return nil, err
+	}
+	if !utils.SelectContextOrWait(ctx, ffSettleTime) {
+		return nil, ctx.Err()
+	}
+	return samples, nil
+}
+
+// fitStaticAndVelocity fits power = kS + kV * velocity by least squares to the samples of a ramp
+// in which the base was moving, returning kS and kV.
+func fitStaticAndVelocity(samples []ffSample) (float64, float64, error) {
+	maxVel := 0.
+	for _, s := range samples {
+		maxVel = math.Max(maxVel, s.vel)
+	}
+	var n, sumV, sumP, sumVV, sumVP float64
+	for _, s := range samples {
+		if s.vel <= ffMovingFrac*maxVel {
+			continue
+		}
+		v := s.vel
+		n++
+		sumV += v
+		sumP += s.power
+		sumVV += v * v
+		sumVP += v * s.power
+	}
+	denom := n*sumVV - sumV*sumV
+	if n < 3 || denom == 0 {
+		return 0, 0, errors.New("the base did not move enough during the ramp, try a larger max_power or ramp_sec")
+	}
+	kv := (n*sumVP - sumV*sumP) / denom
+	ks := (sumP - kv*sumV) / n
+	return math.Max(ks, 0), math.Max(kv, 0), nil
+}
+
+// fitAcceleration fits kA by least squares to the power of a step that kS and kV do not account for,
+// using the samples in which the base was accelerating.
+func fitAcceleration(samples []ffSample, ks, kv float64) float64 {
+	maxAccel := 0.
+	for _, s := range samples {
+		maxAccel = math.Max(maxAccel, s.accel)
+	}
+	var sumRA, sumAA float64
+	for _, s := range samples {
+		if s.accel <= ffAccelFrac*maxAccel || s.vel == 0 {
+			continue
+		}
+		residual := s.power - ks - kv*s.vel
+		sumRA += residual * s.accel
+		sumAA += s.accel * s.accel
+	}
+	if sumAA == 0 {
+		return 0
+	}
+	return math.Max(sumRA/sumAA, 0)
+}
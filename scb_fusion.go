@@ -0,0 +1,251 @@
+package controlledcomponents
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/golang/geo/r3"
+	geo "github.com/kellydunn/golang-geo"
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/components/movementsensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/spatialmath"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const defaultSensorWeight = 1.
+
+// fusedSensor combines the readings of every movement sensor that supports a role into one estimate.
+// It embeds the first capable sensor, so Name and any endpoint that is not fused behave like that sensor.
+// Velocities and positions are combined with a weighted average, while angles use a weighted circular
+// mean so that readings on either side of the +/-180 degree boundary do not cancel out.
+// Only readings in the same frame are fused: position sensors are filtered by frame before they get here,
+// and orientations are taken relative to the first sensor, see alignOrientations.
+// A sensor that errors is left out of the estimate until it recovers.
+type fusedSensor struct {
+	movementsensor.MovementSensor
+	*sensorHealth
+	weights []float64
+
+	// offsets holds the roll, pitch and yaw of each sensor relative to the first sensor in degrees, and aligned
+	// whether the offset of each sensor has been measured yet
+	alignMu sync.Mutex
+	offsets [][3]float64
+	aligned []bool
+}
+
+// newFusedSensor returns a movement sensor that fuses the given sensors using the configured weights.
+// Sensors without a configured weight get the default weight of 1.
+func newFusedSensor(role string, sensors []movementsensor.MovementSensor, weights map[string]float64,
+	logger logging.Logger,
+) *fusedSensor {
+	fs := &fusedSensor{
+		MovementSensor: sensors[0],
+		sensorHealth:   newSensorHealth(role, sensors, logger),
+		weights:        make([]float64, len(sensors)),
+		offsets:        make([][3]float64, len(sensors)),
+		aligned:        make([]bool, len(sensors)),
+	}
+	fs.aligned[0] = true
+	for i, ms := range sensors {
+		fs.weights[i] = defaultSensorWeight
+		if w, ok := weights[ms.Name().ShortName()]; ok {
+			fs.weights[i] = w
+		}
+	}
+	return fs
+}
+
+// sensorNames returns the short names of the fused sensors.
+func (fs *fusedSensor) sensorNames() []string {
+	names := make([]string, 0, len(fs.sensors))
+	for _, ms := range fs.sensors {
+		names = append(names, ms.Name().ShortName())
+	}
+	return names
+}
+
+// readAll calls f on every fused sensor and returns the weight each sensor contributes to the estimate.
+// Sensors that error contribute a weight of zero, and an error is only returned if every sensor failed.
+func (fs *fusedSensor) readAll(ctx context.Context, f func(i int, ms movementsensor.MovementSensor) error,
+) ([]float64, float64, error) {
+	weights := make([]float64, len(fs.sensors))
+	total := 0.
+	var lastErr error
+	for i, ms := range fs.sensors {
+		if err := f(i, ms); err != nil {
+			fs.markFailed(ctx, i, err)
+			lastErr = err
+			continue
+		}
+		fs.markHealthy(ctx, i)
+		weights[i] = fs.weights[i]
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, 0, errors.Wrapf(lastErr, "all %s sensors failed", fs.role)
+	}
+	return weights, total, nil
+}
+
+func (fs *fusedSensor) LinearVelocity(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+	if len(fs.sensors) == 1 {
+		return fs.MovementSensor.LinearVelocity(ctx, extra)
+	}
+	vels := make([]r3.Vector, len(fs.sensors))
+	weights, total, err := fs.readAll(ctx, func(i int, ms movementsensor.MovementSensor) error {
+		var err error
+		vels[i], err = ms.LinearVelocity(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return r3.Vector{}, err
+	}
+	var sum r3.Vector
+	for i, vel := range vels {
+		sum = sum.Add(vel.Mul(weights[i]))
+	}
+	return sum.Mul(1 / total), nil
+}
+
+func (fs *fusedSensor) AngularVelocity(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+	if len(fs.sensors) == 1 {
+		return fs.MovementSensor.AngularVelocity(ctx, extra)
+	}
+	vels := make([]spatialmath.AngularVelocity, len(fs.sensors))
+	weights, total, err := fs.readAll(ctx, func(i int, ms movementsensor.MovementSensor) error {
+		var err error
+		vels[i], err = ms.AngularVelocity(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return spatialmath.AngularVelocity{}, err
+	}
+	var sum r3.Vector
+	for i, vel := range vels {
+		sum = sum.Add(r3.Vector(vel).Mul(weights[i]))
+	}
+	return spatialmath.AngularVelocity(sum.Mul(1 / total)), nil
+}
+
+func (fs *fusedSensor) Position(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+	if len(fs.sensors) == 1 {
+		return fs.MovementSensor.Position(ctx, extra)
+	}
+	points := make([]*geo.Point, len(fs.sensors))
+	alts := make([]float64, len(fs.sensors))
+	weights, total, err := fs.readAll(ctx, func(i int, ms movementsensor.MovementSensor) error {
+		var err error
+		points[i], alts[i], err = ms.Position(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	var lat, lng, alt float64
+	for i, pos := range points {
+		if weights[i] == 0 {
+			continue
+		}
+		lat += pos.Lat() * weights[i]
+		lng += pos.Lng() * weights[i]
+		alt += alts[i] * weights[i]
+	}
+	return geo.NewPoint(lat/total, lng/total), alt / total, nil
+}
+
+func (fs *fusedSensor) Orientation(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+	if len(fs.sensors) == 1 {
+		return fs.MovementSensor.Orientation(ctx, extra)
+	}
+	angles := make([][3]float64, len(fs.sensors))
+	weights, _, err := fs.readAll(ctx, func(i int, ms movementsensor.MovementSensor) error {
+		orient, err := ms.Orientation(ctx, extra)
+		if err != nil {
+			return err
+		}
+		euler := orient.EulerAngles()
+		angles[i] = [3]float64{rdkutils.RadToDeg(euler.Roll), rdkutils.RadToDeg(euler.Pitch), rdkutils.RadToDeg(euler.Yaw)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !fs.alignOrientations(angles, weights) {
+		return nil, errors.Errorf("no %s sensor has been aligned with %s yet", fs.role, fs.sensors[0].Name().ShortName())
+	}
+	rolls := make([]float64, len(angles))
+	pitches := make([]float64, len(angles))
+	yaws := make([]float64, len(angles))
+	for i, angle := range angles {
+		rolls[i], pitches[i], yaws[i] = angle[0], angle[1], angle[2]
+	}
+	return &spatialmath.EulerAngles{
+		Roll:  rdkutils.DegToRad(weightedCircularMean(rolls, weights)),
+		Pitch: rdkutils.DegToRad(weightedCircularMean(pitches, weights)),
+		Yaw:   rdkutils.DegToRad(weightedCircularMean(yaws, weights)),
+	}, nil
+}
+
+// alignOrientations moves the roll, pitch and yaw read from each sensor into the frame of the first sensor,
+// as sensors such as an IMU and wheeled odometry have different zero references. The offset of a sensor is
+// measured the first time it is read together with the first sensor, and a sensor is left out of the estimate
+// until then by zeroing its weight. It returns whether any sensor is left in the estimate.
+func (fs *fusedSensor) alignOrientations(angles [][3]float64, weights []float64) bool {
+	fs.alignMu.Lock()
+	defer fs.alignMu.Unlock()
+	if weights[0] > 0 {
+		for i := range angles {
+			if weights[i] > 0 && !fs.aligned[i] {
+				for axis := range angles[i] {
+					fs.offsets[i][axis] = angles[i][axis] - angles[0][axis]
+				}
+				fs.aligned[i] = true
+			}
+		}
+	}
+	fused := false
+	for i := range angles {
+		if !fs.aligned[i] {
+			weights[i] = 0
+			continue
+		}
+		for axis := range angles[i] {
+			angles[i][axis] -= fs.offsets[i][axis]
+		}
+		fused = fused || weights[i] > 0
+	}
+	return fused
+}
+
+func (fs *fusedSensor) CompassHeading(ctx context.Context, extra map[string]interface{}) (float64, error) {
+	if len(fs.sensors) == 1 {
+		return fs.MovementSensor.CompassHeading(ctx, extra)
+	}
+	headings := make([]float64, len(fs.sensors))
+	weights, _, err := fs.readAll(ctx, func(i int, ms movementsensor.MovementSensor) error {
+		var err error
+		headings[i], err = ms.CompassHeading(ctx, extra)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	// compass headings are reported between [0, 360)
+	heading := weightedCircularMean(headings, weights)
+	if heading < 0 {
+		heading += oneTurn
+	}
+	return heading, nil
+}
+
+// weightedCircularMean computes the weighted mean of angles in degrees, returning a value between (-180,180].
+func weightedCircularMean(anglesDeg, weights []float64) float64 {
+	var sinSum, cosSum float64
+	for i, ang := range anglesDeg {
+		sinSum += weights[i] * math.Sin(rdkutils.DegToRad(ang))
+		cosSum += weights[i] * math.Cos(rdkutils.DegToRad(ang))
+	}
+	return rdkutils.RadToDeg(math.Atan2(sinSum, cosSum))
+}
@@ -0,0 +1,261 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/control"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	// gainBandHysteresis is the fraction of a band's min_speed the setpoint has to fall below before the
+	// band is left, so that a setpoint sitting on the edge of a band does not switch gains every command
+	gainBandHysteresis = 0.1
+	// bumplessTransferTime is how long the output carried over from the previous band takes to fade out,
+	// while the integrator of the new band builds back up
+	bumplessTransferTime = time.Second
+)
+
+// gainSchedule switches the PID gains of each axis by the magnitude of its setpoint. Band 0 of an axis is its
+// control_parameters gains, and band n is the nth gain_schedule entry of the axis in order of min_speed.
+// Switching gains resets the integrator of the PID, so the integral the old gains had built up is
+// added to the output and faded out over bumplessTransferTime to keep the power sent to the base continuous.
+// A nil gainSchedule always uses the control_parameters gains.
+type gainSchedule struct {
+	mu sync.Mutex
+	// bands, active and the transfer state are indexed like configPIDVals, linear then angular
+	bands  [2][]GainBandConfig
+	active [2]int
+	// lastOut is the output of each axis at the previous tick, and offset is the output carried over
+	// by the last switch of band, which fades out from offsetTime
+	lastOut    [2]float64
+	offset     [2]float64
+	offsetTime [2]time.Time
+}
+
+// newGainSchedule returns the gain schedule of the configured bands, or nil if none are configured.
+func newGainSchedule(confs []GainBandConfig) *gainSchedule {
+	if len(confs) == 0 {
+		return nil
+	}
+	gs := &gainSchedule{}
+	for _, conf := range confs {
+		axis := 0
+		if conf.Type == typeAngVel {
+			axis = 1
+		}
+		gs.bands[axis] = append(gs.bands[axis], conf)
+	}
+	for i := range gs.bands {
+		sort.Slice(gs.bands[i], func(a, b int) bool { return gs.bands[i][a].MinSpeed < gs.bands[i][b].MinSpeed })
+	}
+	return gs
+}
+
+// reset returns every axis to its control_parameters gains, which a newly built control loop starts with.
+func (gs *gainSchedule) reset() {
+	if gs == nil {
+		return
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.active = [2]int{}
+	gs.offset = [2]float64{}
+}
+
+// selectBand returns the band an axis should use at a setpoint speed, in mm/s or deg/s, and whether it differs
+// from the band the axis is using.
+func (gs *gainSchedule) selectBand(axis int, speed float64) (int, bool) {
+	if gs == nil {
+		return 0, false
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	band := 0
+	for i, conf := range gs.bands[axis] {
+		minSpeed := conf.MinSpeed
+		// stay in the active band, or any band below it, until the speed is clearly below it
+		if i+1 <= gs.active[axis] {
+			minSpeed *= 1 - gainBandHysteresis
+		}
+		if speed >= minSpeed {
+			band = i + 1
+		}
+	}
+	return band, band != gs.active[axis]
+}
+
+// switchBand makes band the active band of an axis. While the control loop is running, the integral of the
+// previous gains is estimated from the last output, along with any windup held back from it,
+// and the error it was computed from, and carried over.
+func (gs *gainSchedule) switchBand(axis, band int, prevP, lastErr, held float64, running bool, now time.Time) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.active[axis] = band
+	if !running {
+		// a paused loop resets its integrators when it resumes, so there is nothing to carry over
+		gs.offset[axis] = 0
+		return
+	}
+	// the derivative term is left out, as the gains this module tunes use little of it
+	gs.offset[axis] = gs.lastOut[axis] + held - prevP*lastErr*rPiGain
+	gs.offsetTime[axis] = now
+}
+
+// transfer adds the output carried over by the last switch of band to the PID output of an axis at now.
+func (gs *gainSchedule) transfer(axis int, output float64, now time.Time) float64 {
+	if gs == nil {
+		return output
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if remaining := 1 - now.Sub(gs.offsetTime[axis]).Seconds()/bumplessTransferTime.Seconds(); remaining > 0 {
+		output += gs.offset[axis] * remaining
+	}
+	gs.lastOut[axis] = output
+	return output
+}
+
+// band returns the config of band n of an axis, n must be at least 1.
+func (gs *gainSchedule) band(axis, n int) GainBandConfig {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.bands[axis][n-1]
+}
+
+// numBands returns how many gain_schedule bands an axis has, not counting its control_parameters gains.
+func (gs *gainSchedule) numBands(axis int) int {
+	if gs == nil {
+		return 0
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return len(gs.bands[axis])
+}
+
+// setBandGains replaces the gains of band n of an axis with tuned gains.
+func (gs *gainSchedule) setBandGains(axis, n int, tuned control.PIDConfig) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	conf := &gs.bands[axis][n-1]
+	conf.P, conf.I, conf.D = tuned.P, tuned.I, tuned.D
+}
+
+// configs returns the bands of both axes in the form of the gain_schedule attribute.
+func (gs *gainSchedule) configs() []GainBandConfig {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	confs := append([]GainBandConfig{}, gs.bands[0]...)
+	return append(confs, gs.bands[1]...)
+}
+
+// pid returns the gains of the band as a PID config.
+func (conf GainBandConfig) pid() control.PIDConfig {
+	return control.PIDConfig{Type: conf.Type, P: conf.P, I: conf.I, D: conf.D}
+}
+
+// scheduleGains switches the gains of the PID of an axis to the band of its new setpoint,
+// with linear setpoints in m/s and angular setpoints in deg/s.
+func (sb *sensorBase) scheduleGains(ctx context.Context, axis int, setpoint float64) error {
+	speed := math.Abs(setpoint)
+	if axis == 0 {
+		speed *= 1000
+	}
+	band, changed := sb.schedule.selectBand(axis, speed)
+	if !changed || sb.loop == nil {
+		return nil
+	}
+
+	pidName := sb.blockNames[pidBlockType][axis]
+	prev, err := sb.loop.ConfigAt(ctx, pidName)
+	if err != nil {
+		return err
+	}
+	prevP := 0.
+	if sets, ok := prev.Attribute["PIDSets"].([]*control.PIDConfig); ok && len(sets) != 0 {
+		prevP = sets[0].P
+	}
+	gains := sb.configPIDVals[axis]
+	if band > 0 {
+		gains = sb.schedule.band(axis, band).pid()
+	}
+
+	// the error the PID last acted on, from the setpoint it was following and the latest measurement
+	current, _ := sb.history.snapshot(0)
+	lastErr := current.linearSetpoint - current.linearMeasured
+	if axis == 1 {
+		lastErr = current.angularSetpoint - current.angularMeasured
+	}
+	held := sb.saturation.takeHeld(axis)
+	sb.schedule.switchBand(axis, band, prevP, lastErr, held, sb.loop.Running(), time.Now())
+	sb.saturation.setIntegralGain(axis, gains.I)
+
+	attrs := make(rdkutils.AttributeMap, len(prev.Attribute))
+	for k, v := range prev.Attribute {
+		attrs[k] = v
+	}
+	attrs["PIDSets"] = []*control.PIDConfig{&gains}
+	prev.Attribute = attrs
+	sb.logger.CDebugf(ctx, "switching %s to gain band %d %v", axisName(axis), band, gains)
+	return sb.loop.SetConfigAt(ctx, pidName, prev)
+}
+
+// bandTuningRequests returns the tuning runs a start_tuning request asks for, one for each band it tunes.
+// A band without a step_pct of its own is tuned at the power its feedforward gains predict for its min_speed.
+func (sb *sensorBase) bandTuningRequests(tr tuningRequest) ([]tuningRequest, error) {
+	if tr.band == 0 && !tr.allBands {
+		return []tuningRequest{tr}, nil
+	}
+	numBands := sb.schedule.numBands(tr.axis)
+	if tr.axis == latAxis || numBands == 0 {
+		return nil, fmt.Errorf("%s has no gain_schedule bands to tune", axisName(tr.axis))
+	}
+	if tr.band > numBands {
+		return nil, fmt.Errorf("%s has %d gain_schedule bands, cannot tune band %d", axisName(tr.axis), numBands, tr.band)
+	}
+
+	bands := []int{tr.band}
+	if tr.allBands {
+		bands = nil
+		for n := 1; n <= numBands; n++ {
+			bands = append(bands, n)
+		}
+	}
+	requests := make([]tuningRequest, 0, len(bands))
+	for _, n := range bands {
+		req := tr
+		req.band, req.allBands = n, false
+		if tr.stepPct == 0 {
+			speed := sb.schedule.band(tr.axis, n).MinSpeed
+			if tr.axis == 0 {
+				// the feedforward gains map setpoints in m/s
+				speed /= 1000
+			}
+			power, ok := sb.ff.power(tr.axis, speed)
+			if !ok {
+				return nil, fmt.Errorf("tuning %s gain_schedule bands needs a step_pct or %s feedforward gains",
+					axisName(tr.axis), axisName(tr.axis))
+			}
+			req.stepPct = math.Min(power, 1)
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// tuningTarget describes the gains a tuning request tunes, for logs and responses.
+func tuningTarget(tr tuningRequest) string {
+	switch {
+	case tr.allBands:
+		return fmt.Sprintf("every %s gain_schedule band", axisName(tr.axis))
+	case tr.band > 0:
+		return fmt.Sprintf("%s gain_schedule band %d", axisName(tr.axis), tr.band)
+	default:
+		return axisName(tr.axis)
+	}
+}
@@ -0,0 +1,194 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/geo/r3"
+	geo "github.com/kellydunn/golang-geo"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	safetyGeofence = "geofence"
+	// defaultGeofenceMarginM is how much further from the geofence a base that is already outside it may move
+	// before it is stopped, so that the noise of the position sensor does not stop a base being driven back in
+	defaultGeofenceMarginM = 1.
+	// geofenceLookaheadSec is how far ahead a SetVelocity is projected to check that it does not leave the geofence
+	geofenceLookaheadSec = 1.
+)
+
+// ErrGeofence matches, with errors.Is, the error a command returns when the geofence stopped the base.
+var ErrGeofence = &SafetyStopError{Check: safetyGeofence}
+
+// geofence is the area the base is kept in, either a polygon or a circle around a home point.
+type geofence struct {
+	polygon  []*geo.Point
+	home     *geo.Point
+	radiusMm float64
+	marginMm float64
+}
+
+// newGeofence returns the geofence of a config, or nil if none is configured.
+func newGeofence(conf *GeofenceConfig) *geofence {
+	if conf == nil {
+		return nil
+	}
+	gf := &geofence{radiusMm: conf.RadiusM * 1000, marginMm: defaultGeofenceMarginM * 1000}
+	if conf.MarginM != 0 {
+		gf.marginMm = conf.MarginM * 1000
+	}
+	if conf.Home != nil {
+		gf.home = geo.NewPoint(conf.Home.Lat, conf.Home.Lng)
+	}
+	for _, p := range conf.Polygon {
+		gf.polygon = append(gf.polygon, geo.NewPoint(p.Lat, p.Lng))
+	}
+	return gf
+}
+
+// outsideMm returns how far outside the geofence a position is in mm, or 0 if it is inside.
+// disp returns the displacement between two positions in the position frame, in mm.
+func (gf *geofence) outsideMm(pos *geo.Point, disp func(from, to *geo.Point) r3.Vector) float64 {
+	edgeMm, inside := gf.edgeDistanceMm(pos, disp)
+	if inside {
+		return 0
+	}
+	return edgeMm
+}
+
+// clearanceMm returns how far inside the geofence a position is from its edge in mm, or 0 if it is outside.
+func (gf *geofence) clearanceMm(pos *geo.Point, disp func(from, to *geo.Point) r3.Vector) float64 {
+	edgeMm, inside := gf.edgeDistanceMm(pos, disp)
+	if !inside {
+		return 0
+	}
+	return edgeMm
+}
+
+// edgeDistanceMm returns how far a position is from the edge of the geofence in mm, and whether it is inside.
+func (gf *geofence) edgeDistanceMm(pos *geo.Point, disp func(from, to *geo.Point) r3.Vector) (float64, bool) {
+	if gf.home != nil {
+		fromEdge := disp(gf.home, pos).Norm() - gf.radiusMm
+		return math.Abs(fromEdge), fromEdge <= 0
+	}
+	// the vertices are taken relative to the position, so that the position is at the origin
+	verts := make([]r3.Vector, len(gf.polygon))
+	for i, p := range gf.polygon {
+		verts[i] = disp(pos, p)
+	}
+	inside := false
+	nearest := math.Inf(1)
+	for i, a := range verts {
+		b := verts[(i+1)%len(verts)]
+		// count the edges crossed by a ray from the origin along +X
+		if (a.Y > 0) != (b.Y > 0) && a.X-a.Y*(b.X-a.X)/(b.Y-a.Y) > 0 {
+			inside = !inside
+		}
+		nearest = math.Min(nearest, originToSegment(a, b))
+	}
+	return nearest, inside
+}
+
+// originToSegment returns the distance from the origin to the segment from a to b.
+func originToSegment(a, b r3.Vector) float64 {
+	edge := b.Sub(a)
+	if edge.Norm2() == 0 {
+		return a.Norm()
+	}
+	t := math.Max(0, math.Min(1, -a.Dot(edge)/edge.Norm2()))
+	return a.Add(edge.Mul(t)).Norm()
+}
+
+// offsetPosition returns the position a displacement in mm away from a position, the inverse of displacementMm.
+func (sb *sensorBase) offsetPosition(from *geo.Point, dispMm r3.Vector) *geo.Point {
+	if sb.positionFrame == frameLocal {
+		return geo.NewPoint(from.Lat()+dispMm.Y/1000, from.Lng()+dispMm.X/1000)
+	}
+	bearing := rdkutils.RadToDeg(math.Atan2(dispMm.X, dispMm.Y))
+	return from.PointAtDistanceAndBearing(dispMm.Norm()/1000000, bearing)
+}
+
+// noteGeofence reads the position of the base and records how far outside the geofence it is,
+// for the control loop to check. It is called from State, so a failed read only skips the tick.
+func (sb *sensorBase) noteGeofence(ctx context.Context) {
+	if sb.geofence == nil || sb.position == nil {
+		return
+	}
+	pos, err := sb.readPosition(ctx)
+	if err != nil {
+		sb.logger.CDebugf(ctx, "could not read the position to check the geofence: %v", err)
+		return
+	}
+	sb.safety.noteOutside(sb.geofence.outsideMm(pos, sb.displacementMm))
+}
+
+// checkGeofenceTarget stops the base and returns a fault if a motion from start by dispMm would end outside
+// the geofence and further from it than the base already is. Motions that end closer to the fence are allowed,
+// so that a base outside it can be driven back in. The caller must not hold sb.mu.
+func (sb *sensorBase) checkGeofenceTarget(ctx context.Context, command string, start *geo.Point, dispMm r3.Vector) error {
+	if sb.geofence == nil {
+		return nil
+	}
+	from := sb.geofence.outsideMm(start, sb.displacementMm)
+	to := sb.geofence.outsideMm(sb.offsetPosition(start, dispMm), sb.displacementMm)
+	if to == 0 || to <= from {
+		return nil
+	}
+	return sb.refuseOutsideGeofence(ctx, fmt.Sprintf("the %s target is %.1f m outside the geofence", command, to/1000))
+}
+
+// checkGeofenceReach stops the base and returns a fault if a motion of reachMm from start in an unknown direction
+// could end outside the geofence, for a MoveStraight whose course is only known once the base is moving.
+// The caller must not hold sb.mu.
+func (sb *sensorBase) checkGeofenceReach(ctx context.Context, command string, start *geo.Point, reachMm float64) error {
+	if sb.geofence == nil {
+		return nil
+	}
+	if clearance := sb.geofence.clearanceMm(start, sb.displacementMm); reachMm <= clearance {
+		return nil
+	}
+	return sb.refuseOutsideGeofence(ctx, fmt.Sprintf(
+		"the %s course is unknown and a move of %.1f m could leave the geofence, add a heading sensor in the position frame",
+		command, reachMm/1000))
+}
+
+// checkGeofenceVelocity stops the base and returns a fault if a SetVelocity would take the base outside the geofence,
+// or further from it, within geofenceLookaheadSec. Without a heading in the position frame the direction of the
+// command is unknown, so it is left to the control loop to stop the base if it leaves the fence.
+// The caller must not hold sb.mu.
+func (sb *sensorBase) checkGeofenceVelocity(ctx context.Context, linear r3.Vector) error {
+	if sb.geofence == nil || linear == (r3.Vector{}) {
+		return nil
+	}
+	heading, hasHeading, err := sb.courseHeading(ctx)
+	if err != nil || !hasHeading {
+		return err
+	}
+	start, err := sb.readPosition(ctx)
+	if err != nil {
+		return err
+	}
+	// forward is along the heading and lateral is to its right, both in the position frame
+	headingRad := rdkutils.DegToRad(heading)
+	forward := r3.Vector{X: -math.Sin(headingRad), Y: math.Cos(headingRad)}
+	right := r3.Vector{X: math.Cos(headingRad), Y: math.Sin(headingRad)}
+	disp := forward.Mul(linear.Y * geofenceLookaheadSec).Add(right.Mul(linear.X * geofenceLookaheadSec))
+	return sb.checkGeofenceTarget(ctx, "SetVelocity", start, disp)
+}
+
+// refuseOutsideGeofence stops the base for a command that would take it outside the geofence and returns the fault.
+// The caller must not hold sb.mu.
+func (sb *sensorBase) refuseOutsideGeofence(ctx context.Context, detail string) error {
+	fault := &SafetyStopError{Check: safetyGeofence, Detail: detail, Time: time.Now()}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if err := sb.safetyStop(ctx, fault); err != nil {
+		sb.logger.CError(ctx, err)
+	}
+	// the fault is returned by the command itself, so the next SetVelocity does not need to return it
+	sb.safety.clearUnreported()
+	return fault
+}
@@ -0,0 +1,89 @@
+package controlledcomponents
+
+import (
+	"context"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// headingHoldKey is the extra key that overrides the heading_hold attribute for a single SetVelocity call.
+const headingHoldKey = "heading_hold"
+
+// headingHoldEnabled returns whether SetVelocity should hold the heading of the base,
+// using the value in extra if one was given and the configured value otherwise.
+func (sb *sensorBase) headingHoldEnabled(extra map[string]interface{}) bool {
+	if hold, ok := extra[headingHoldKey].(bool); ok {
+		return hold
+	}
+	return sb.conf != nil && sb.conf.HeadingHold
+}
+
+// startHeadingHold latches the current heading of the base and steers the angular setpoint
+// back towards it in the background, the same way MoveStraight holds its initial heading.
+// The hold runs until stopHeadingHold is called by the next command.
+func (sb *sensorBase) startHeadingHold(ctx context.Context, gain float64) error {
+	heading, hasHeading, err := sb.headingFunc(ctx)
+	if err != nil {
+		return err
+	}
+	if !hasHeading {
+		sb.logger.CWarn(ctx, "heading hold requested but no orientation or compass heading sensor is configured, ignoring it")
+		return nil
+	}
+
+	holdCtx, holdCancel := context.WithCancel(sb.cancelCtx)
+	holdDone := make(chan struct{})
+	sb.mu.Lock()
+	sb.headingHoldCancel = holdCancel
+	sb.headingHoldDone = holdDone
+	sb.mu.Unlock()
+
+	sb.logger.CDebugf(ctx, "holding heading %.2f", heading)
+	sb.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		defer close(holdDone)
+		ticker := time.NewTicker(time.Duration(1000./sb.controlLoopConfig.Frequency) * time.Millisecond)
+		defer ticker.Stop()
+		failing := false
+		for {
+			select {
+			case <-holdCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			angVelDes, err := sb.calcHeadingControl(holdCtx, heading, gain)
+			if err == nil && sb.loop != nil {
+				err = sb.updateAngularSetpoint(holdCtx, angVelDes)
+			}
+			if err != nil {
+				if holdCtx.Err() != nil {
+					return
+				}
+				// keep trying, the base still follows the last setpoint while the heading cannot be read
+				if !failing {
+					sb.logger.Warnf("heading hold could not correct the heading: %v", err)
+				}
+				failing = true
+				continue
+			}
+			failing = false
+		}
+	}, sb.activeBackgroundWorkers.Done)
+	return nil
+}
+
+// stopHeadingHold releases the latched heading and waits for the background correction to stop,
+// so that it cannot overwrite the setpoints of the command that replaced it.
+func (sb *sensorBase) stopHeadingHold() {
+	sb.mu.Lock()
+	cancel, holdDone := sb.headingHoldCancel, sb.headingHoldDone
+	sb.headingHoldCancel = nil
+	sb.headingHoldDone = nil
+	sb.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-holdDone
+}
@@ -0,0 +1,210 @@
+package controlledcomponents
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	getControlHistory = "get_control_history"
+	// controlHistorySize is how many control loop ticks are kept, a minute of history at the default frequency
+	controlHistorySize = 600
+)
+
+// controlSample is the state of the controller at one tick of the control loop.
+type controlSample struct {
+	time time.Time
+	// setpoints in m/s and deg/s
+	linearSetpoint  float64
+	angularSetpoint float64
+	// velocities measured by the velocity sensor in m/s and deg/s
+	linearMeasured  float64
+	angularMeasured float64
+	// PID outputs sent to the base as powers between -1 and 1
+	linearOutput  float64
+	angularOutput float64
+	headingDeg    float64
+	// positionErrMm is the distance left to the goal of a MoveStraight or move_to_pose
+	positionErrMm float64
+}
+
+// controlHistory is a ring buffer of the most recent control loop ticks.
+// The setpoints, measurements, heading and position error are noted as they change,
+// and a sample of them is recorded each time the loop sends its outputs to the base.
+// Samples are also queued to be written to the run being logged, if there is one.
+type controlHistory struct {
+	mu      sync.Mutex
+	current controlSample
+	samples []controlSample
+	next    int
+	run     *runLog
+	// headingTime is when the heading was last noted, zero if it never has been
+	headingTime time.Time
+}
+
+func newControlHistory(size int) *controlHistory {
+	return &controlHistory{samples: make([]controlSample, 0, size)}
+}
+
+func (h *controlHistory) noteSetpoints(linear, angular float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.linearSetpoint = linear
+	h.current.angularSetpoint = angular
+}
+
+func (h *controlHistory) noteAngularSetpoint(angular float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.angularSetpoint = angular
+}
+
+func (h *controlHistory) noteMeasured(linear, angular float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.linearMeasured = linear
+	h.current.angularMeasured = angular
+}
+
+func (h *controlHistory) noteHeading(heading float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.headingDeg = heading
+	h.headingTime = time.Now()
+}
+
+// lastHeading returns the heading last noted by a motion that steers by it and when it was noted,
+// or a zero time if no heading has been noted.
+func (h *controlHistory) lastHeading() (float64, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.current.headingDeg, h.headingTime
+}
+
+func (h *controlHistory) notePositionErr(errMm float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.positionErrMm = errMm
+}
+
+// record adds a sample with the latest outputs of the control loop, replacing the oldest sample once the buffer is full.
+func (h *controlHistory) record(linearOutput, angularOutput float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sample := h.current
+	sample.time = time.Now()
+	sample.linearOutput = linearOutput
+	sample.angularOutput = angularOutput
+	if h.run != nil {
+		h.run.enqueue(sample)
+	}
+	if len(h.samples) < cap(h.samples) {
+		h.samples = append(h.samples, sample)
+		return
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+}
+
+// attachRun starts writing recorded samples to run.
+func (h *controlHistory) attachRun(run *runLog) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.run = run
+}
+
+// runKind returns the kind of run being recorded, or an empty string if the base is idle.
+func (h *controlHistory) runKind() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil {
+		return ""
+	}
+	return h.run.kind
+}
+
+// detachRun stops writing samples to run and returns it, or returns nil if run is not being written.
+// A nil run detaches whichever run is being written.
+func (h *controlHistory) detachRun(run *runLog) *runLog {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.run == nil || (run != nil && h.run != run) {
+		return nil
+	}
+	detached := h.run
+	h.run = nil
+	return detached
+}
+
+// snapshot returns the values noted since the last sample along with the last n samples, oldest first.
+func (h *controlHistory) snapshot(n int) (controlSample, []controlSample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n = min(n, len(h.samples))
+	recent := make([]controlSample, 0, n)
+	for i := len(h.samples) - n; i < len(h.samples); i++ {
+		recent = append(recent, h.samples[(h.next+i)%len(h.samples)])
+	}
+	return h.current, recent
+}
+
+// between returns the samples recorded within [start, end] in the order they were recorded.
+// A zero start or end leaves that side of the window open.
+func (h *controlHistory) between(start, end time.Time) []controlSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var samples []controlSample
+	for i := range h.samples {
+		sample := h.samples[(h.next+i)%len(h.samples)]
+		if !start.IsZero() && sample.time.Before(start) || !end.IsZero() && sample.time.After(end) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// parseHistoryWindow reads the time window of a get_control_history DoCommand. The window is either the
+// last last_sec seconds, or between start_time and end_time given as RFC3339 timestamps. No options returns everything.
+func parseHistoryWindow(raw interface{}) (time.Time, time.Time, error) {
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return time.Time{}, time.Time{}, nil
+	}
+	var start, end time.Time
+	if lastSec, ok := opts["last_sec"].(float64); ok {
+		if lastSec <= 0 {
+			return start, end, errors.New("get_control_history last_sec must be greater than 0")
+		}
+		start = time.Now().Add(-time.Duration(lastSec * float64(time.Second)))
+	}
+	for name, t := range map[string]*time.Time{"start_time": &start, "end_time": &end} {
+		raw, ok := opts[name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return start, end, fmt.Errorf("get_control_history %s must be an RFC3339 timestamp", name)
+		}
+		*t = parsed
+	}
+	return start, end, nil
+}
+
+// toMap converts the sample into a form DoCommand can return.
+func (s controlSample) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"time":                       s.time.Format(time.RFC3339Nano),
+		"linear_setpoint_m_per_s":    s.linearSetpoint,
+		"angular_setpoint_deg_per_s": s.angularSetpoint,
+		"linear_measured_m_per_s":    s.linearMeasured,
+		"angular_measured_deg_per_s": s.angularMeasured,
+		"linear_output":              s.linearOutput,
+		"angular_output":             s.angularOutput,
+		"heading_deg":                s.headingDeg,
+		"position_error_mm":          s.positionErrMm,
+	}
+}
@@ -0,0 +1,180 @@
+package controlledcomponents
+
+import (
+	"context"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/rdk/control"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	typeLatVel = "lateral_velocity"
+	// latAxis is the index tuning requests use for the lateral axis, after linear (0) and angular (1).
+	latAxis = 2
+	// rPiGain matches the gain the control package puts after each PID block, mapping the
+	// PID output range of +/-255 to a power between -1 and 1.
+	rPiGain = 0.00392157
+)
+
+// lateralControl is the optional third PID axis used by holonomic bases, such as mecanum and omni bases.
+// The control package only builds two-axis loops for bases, so the lateral axis runs as its own
+// one-dimensional loop and SetState combines its output with the linear and angular outputs.
+type lateralControl struct {
+	conf       control.PIDConfig
+	tuned      control.PIDConfig
+	loopConfig control.Config
+	loop       *control.Loop
+	// power is the latest output of the lateral PID, applied on the next SetState of the main loop
+	power  float64
+	tuning bool
+}
+
+// lateralControllable is the controllable the lateral loop's endpoint calls into.
+type lateralControllable struct {
+	sb *sensorBase
+}
+
+// State returns the measured lateral (X) velocity of the base.
+func (lc *lateralControllable) State(ctx context.Context) ([]float64, error) {
+	linvel, err := lc.sb.velocities.LinearVelocity(ctx, nil)
+	if err != nil {
+		return []float64{}, err
+	}
+	return []float64{linvel.X}, nil
+}
+
+// SetState stores the lateral PID output for the main loop to apply. While the lateral axis
+// is being tuned the main loop is stopped, so the output is sent to the base directly.
+func (lc *lateralControllable) SetState(ctx context.Context, state []*control.Signal) error {
+	sb := lc.sb
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.lateral == nil {
+		return nil
+	}
+	if sb.lateral.tuning {
+		sb.lateral.power = state[0].GetSignalValueAt(0)
+		return sb.controlledBase.SetPower(ctx, r3.Vector{X: sb.lateral.power}, r3.Vector{}, nil)
+	}
+	if sb.lateral.loop == nil || !sb.lateral.loop.Running() {
+		sb.lateral.power = 0
+		return nil
+	}
+	sb.lateral.power = state[0].GetSignalValueAt(0)
+	return nil
+}
+
+// lateralControlConfig creates a one-dimensional velocity loop of
+// constant -> sum -> PID -> gain -> endpoint -> sum for the lateral axis.
+func lateralControlConfig(pidConf control.PIDConfig, baseName string, freq float64) control.Config {
+	return control.Config{
+		Blocks: []control.BlockConfig{
+			control.CreateConstantBlock(context.Background(), "lateral_set_point", 0),
+			{
+				Name:      "lateral_sum",
+				Type:      "sum",
+				Attribute: rdkutils.AttributeMap{"sum_string": "+-"},
+				DependsOn: []string{"lateral_set_point", "lateral_endpoint"},
+			},
+			{
+				Name: "lateral_PID",
+				Type: pidBlockType,
+				Attribute: rdkutils.AttributeMap{
+					"int_sat_lim_lo": -255.0,
+					"int_sat_lim_up": 255.0,
+					"PIDSets":        []*control.PIDConfig{&pidConf},
+					"limit_lo":       -255.0,
+					"limit_up":       255.0,
+					"tune_method":    "ziegerNicholsPI",
+					"tune_ssr_value": 2.0,
+					"tune_step_pct":  defaultTuneStepPct,
+				},
+				DependsOn: []string{"lateral_sum"},
+			},
+			{
+				Name:      "lateral_gain",
+				Type:      "gain",
+				Attribute: rdkutils.AttributeMap{"gain": rPiGain},
+				DependsOn: []string{"lateral_PID"},
+			},
+			{
+				Name:      "lateral_endpoint",
+				Type:      "endpoint",
+				Attribute: rdkutils.AttributeMap{"motor_name": baseName},
+				DependsOn: []string{"lateral_gain"},
+			},
+		},
+		Frequency: freq,
+	}
+}
+
+// setupLateralControl prepares the lateral loop config. The loop itself is started with the main loop.
+func (sb *sensorBase) setupLateralControl(pidConf control.PIDConfig) {
+	if sb.lateral == nil {
+		return
+	}
+	sb.lateral.conf = pidConf
+	sb.lateral.loopConfig = lateralControlConfig(pidConf, sb.Name().ShortName(), sb.controlFreq)
+}
+
+// startLateralLoop starts the lateral loop when a lateral axis is configured.
+func (sb *sensorBase) startLateralLoop() error {
+	if sb.lateral == nil || sb.lateral.loop != nil {
+		return nil
+	}
+	loop, err := control.NewLoop(sb.logger, sb.lateral.loopConfig, &lateralControllable{sb: sb})
+	if err != nil {
+		return err
+	}
+	if err := loop.Start(); err != nil {
+		return err
+	}
+	sb.lateral.loop = loop
+	return nil
+}
+
+// tuneLateral runs the auto-tuner on the lateral loop and swaps the tuned gains into it.
+func (sb *sensorBase) tuneLateral(ctx context.Context, tr tuningRequest) (control.PIDConfig, error) {
+	tuneConf := lateralControlConfig(control.PIDConfig{Type: typeLatVel}, sb.Name().ShortName(), sb.controlFreq)
+	tuneConf.Blocks[2].Attribute["tune_step_pct"] = tr.stepPct
+
+	sb.mu.Lock()
+	sb.lateral.tuning = true
+	sb.mu.Unlock()
+	defer func() {
+		sb.mu.Lock()
+		sb.lateral.tuning = false
+		sb.lateral.power = 0
+		sb.mu.Unlock()
+	}()
+
+	loop, err := control.NewLoop(sb.logger, tuneConf, &lateralControllable{sb: sb})
+	if err != nil {
+		return control.PIDConfig{}, err
+	}
+	if err := loop.Start(); err != nil {
+		return control.PIDConfig{}, err
+	}
+	loop.MonitorTuning(ctx)
+	tuned := loop.GetPIDVals(0)
+	loop.Stop()
+	return tuned, nil
+}
+
+// updateLateralSetpoint sets the lateral velocity setpoint in m/s.
+func (sb *sensorBase) updateLateralSetpoint(ctx context.Context, lateralValue float64) error {
+	if sb.lateral == nil || sb.lateral.loop == nil {
+		return nil
+	}
+	return control.UpdateConstantBlock(ctx, "lateral_set_point", lateralValue, sb.lateral.loop)
+}
+
+// lateralPower returns the output of the lateral PID to combine with the other axes.
+// The caller must hold sb.mu.
+func (sb *sensorBase) lateralPower() float64 {
+	if sb.lateral == nil {
+		return 0
+	}
+	return sb.lateral.power
+}
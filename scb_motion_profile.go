@@ -0,0 +1,100 @@
+package controlledcomponents
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	profileNone        = "none"
+	profileTrapezoidal = "trapezoidal"
+	profileSCurve      = "s_curve"
+)
+
+// motionProfile shapes the velocity setpoint of MoveStraight and Spin so that the base accelerates
+// and decelerates at a limited rate instead of jumping to the commanded speed.
+// The trapezoidal profile limits acceleration, and the s-curve profile also limits jerk by ramping
+// the acceleration itself. Both slow down in time to stop at the goal, so the legacy slow down
+// distance and angle are not used when a profile is configured.
+type motionProfile struct {
+	kind     string
+	maxAccel float64
+	maxJerk  float64
+
+	vel   float64
+	accel float64
+}
+
+// newMotionProfile returns the profile for one axis, or nil if the legacy ramp should be used.
+func newMotionProfile(kind string, maxAccel, maxJerk float64) *motionProfile {
+	switch kind {
+	case profileTrapezoidal, profileSCurve:
+		return &motionProfile{kind: kind, maxAccel: maxAccel, maxJerk: maxJerk}
+	default:
+		return nil
+	}
+}
+
+// next advances the profile by dt seconds and returns the velocity setpoint.
+// cruise is the magnitude of the commanded velocity and remaining is the signed distance left to the goal,
+// in matching units such as mm and mm/s or degrees and deg/s.
+func (mp *motionProfile) next(cruise, remaining, dt float64) float64 {
+	target := sign(remaining) * math.Min(math.Abs(cruise), mp.stoppingVel(math.Abs(remaining)))
+
+	if mp.kind == profileTrapezoidal {
+		mp.vel += clamp(target-mp.vel, mp.maxAccel*dt)
+		return mp.vel
+	}
+
+	// reduce the acceleration early enough that the velocity settles on the target instead of overshooting it
+	velErr := target - mp.vel
+	accelDes := sign(velErr) * math.Min(mp.maxAccel, math.Sqrt(2*mp.maxJerk*math.Abs(velErr)))
+	mp.accel += clamp(accelDes-mp.accel, mp.maxJerk*dt)
+	newVel := mp.vel + mp.accel*dt
+	// do not step past the target, the acceleration ramps back down on the next tick
+	if (newVel-target)*(mp.vel-target) < 0 {
+		newVel = target
+		mp.accel = 0
+	}
+	mp.vel = newVel
+	return mp.vel
+}
+
+// rampTime returns how much longer a move at cruise speed takes with the profile than without it,
+// used to extend the timeout of the move.
+func (mp *motionProfile) rampTime(cruise float64) time.Duration {
+	if mp == nil {
+		return 0
+	}
+	rampSecs := math.Abs(cruise) / mp.maxAccel
+	if mp.kind == profileSCurve {
+		rampSecs += mp.maxAccel / mp.maxJerk
+	}
+	return time.Duration(rampSecs * float64(time.Second))
+}
+
+// stoppingVel returns the highest speed the base can have while still being able to stop within dist.
+func (mp *motionProfile) stoppingVel(dist float64) float64 {
+	if mp.kind == profileTrapezoidal {
+		return math.Sqrt(2 * mp.maxAccel * dist)
+	}
+	// ramping the deceleration up and down adds v*a/(2j) to the stopping distance v^2/(2a),
+	// so solve v^2 + v*a^2/j - 2*a*dist = 0 for v
+	rampTerm := mp.maxAccel * mp.maxAccel / mp.maxJerk
+	return (-rampTerm + math.Sqrt(rampTerm*rampTerm+8*mp.maxAccel*dist)) / 2
+}
+
+// linearProfile returns a new profile for the linear velocity of MoveStraight, or nil if none is configured.
+func (sb *sensorBase) linearProfile() *motionProfile {
+	return newMotionProfile(sb.conf.MotionProfile, sb.conf.MaxLinearAccel, sb.conf.MaxLinearJerk)
+}
+
+// angularProfile returns a new profile for the angular velocity of Spin, or nil if none is configured.
+func (sb *sensorBase) angularProfile() *motionProfile {
+	return newMotionProfile(sb.conf.MotionProfile, sb.conf.MaxAngularAccel, sb.conf.MaxAngularJerk)
+}
+
+// clamp limits x to the range [-limit, limit].
+func clamp(x, limit float64) float64 {
+	return math.Max(-limit, math.Min(limit, x))
+}
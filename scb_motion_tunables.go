@@ -0,0 +1,130 @@
+package controlledcomponents
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTimeoutMultiplier = 5.  // multiple of the estimated time a move may take before it is stopped
+	defaultMinTimeoutSec     = 10. // shortest timeout of a move
+)
+
+// motionTunables holds the tolerances, slow down behavior and timeouts of MoveStraight and Spin.
+// The values come from the defaults, then the config, then the extra map of the call, in that order.
+type motionTunables struct {
+	spinToleranceDeg      float64
+	spinSlowDownGain      float64
+	maxSpinSlowDownDeg    float64
+	straightSlowDownGain  float64
+	maxStraightSlowDownMm float64
+	headingGain           float64
+	timeoutMultiplier     float64
+	minTimeoutSec         float64
+}
+
+// tunableField names a tunable by its config and extra key.
+type tunableField struct {
+	name  string
+	value *float64
+	// max is the largest accepted value, zero means there is no upper bound
+	max float64
+}
+
+func defaultMotionTunables() motionTunables {
+	return motionTunables{
+		spinToleranceDeg:      boundCheckTarget,
+		spinSlowDownGain:      slowDownAngGain,
+		maxSpinSlowDownDeg:    maxSlowDownAng,
+		straightSlowDownGain:  slowDownDistGain,
+		maxStraightSlowDownMm: maxSlowDownDist,
+		headingGain:           headingGain,
+		timeoutMultiplier:     defaultTimeoutMultiplier,
+		minTimeoutSec:         defaultMinTimeoutSec,
+	}
+}
+
+// motionTunables returns the configured tunables, where zero means the default is used.
+func (cfg *SCBConfig) motionTunables() motionTunables {
+	return motionTunables{
+		spinToleranceDeg:      cfg.SpinToleranceDeg,
+		spinSlowDownGain:      cfg.SpinSlowDownGain,
+		maxSpinSlowDownDeg:    cfg.MaxSpinSlowDownDeg,
+		straightSlowDownGain:  cfg.StraightSlowDownGain,
+		maxStraightSlowDownMm: cfg.MaxStraightSlowDownMm,
+		headingGain:           cfg.HeadingGain,
+		timeoutMultiplier:     cfg.TimeoutMultiplier,
+		minTimeoutSec:         cfg.MinTimeoutSec,
+	}
+}
+
+func (mt *motionTunables) fields() []tunableField {
+	return []tunableField{
+		{name: "spin_tolerance_deg", value: &mt.spinToleranceDeg},
+		{name: "spin_slow_down_gain", value: &mt.spinSlowDownGain, max: 1},
+		{name: "max_spin_slow_down_deg", value: &mt.maxSpinSlowDownDeg},
+		{name: "straight_slow_down_gain", value: &mt.straightSlowDownGain, max: 1},
+		{name: "max_straight_slow_down_mm", value: &mt.maxStraightSlowDownMm},
+		{name: "heading_gain", value: &mt.headingGain},
+		{name: "timeout_multiplier", value: &mt.timeoutMultiplier},
+		{name: "min_timeout_sec", value: &mt.minTimeoutSec},
+	}
+}
+
+// validate checks the configured tunables, allowing zero for any that were not set.
+func (mt motionTunables) validate() error {
+	for _, f := range mt.fields() {
+		if *f.value == 0 {
+			continue
+		}
+		if err := f.check(*f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f tunableField) check(value float64) error {
+	if value <= 0 {
+		return errors.Errorf("%s must be greater than 0", f.name)
+	}
+	if f.max != 0 && value > f.max {
+		return errors.Errorf("%s must be at most %v", f.name, f.max)
+	}
+	return nil
+}
+
+// motionTunables returns the tunables for a single MoveStraight, Spin or SetVelocity call.
+func (sb *sensorBase) motionTunables(extra map[string]interface{}) (motionTunables, error) {
+	mt := defaultMotionTunables()
+	configured := sb.conf.motionTunables()
+	configuredFields := configured.fields()
+	for i, f := range mt.fields() {
+		if v := *configuredFields[i].value; v != 0 {
+			*f.value = v
+		}
+		raw, ok := extra[f.name]
+		if !ok {
+			continue
+		}
+		v, ok := raw.(float64)
+		if !ok {
+			return motionTunables{}, errors.Errorf("extra %s must be a number", f.name)
+		}
+		if err := f.check(v); err != nil {
+			return motionTunables{}, err
+		}
+		*f.value = v
+	}
+	return mt, nil
+}
+
+// timeout returns how long a move estimated to take timeEst may run before it is stopped.
+func (mt motionTunables) timeout(timeEst time.Duration) time.Duration {
+	timeOut := time.Duration(mt.timeoutMultiplier * float64(timeEst))
+	if minTimeout := time.Duration(mt.minTimeoutSec * float64(time.Second)); timeOut < minTimeout {
+		return minTimeout
+	}
+	return timeOut
+}
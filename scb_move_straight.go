@@ -3,12 +3,14 @@ package controlledcomponents
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"time"
 
-	geo "github.com/kellydunn/golang-geo"
+	"github.com/golang/geo/r3"
 )
 
+// defaults of the MoveStraight tunables.
 const (
 	slowDownDistGain      = .1
 	maxSlowDownDist       = 100 // mm
@@ -16,6 +18,9 @@ const (
 	headingGain           = 1.
 )
 
+// overshootToleranceKey is the extra key that overrides the overshoot_tolerance_mm attribute for a single MoveStraight call.
+const overshootToleranceKey = "overshoot_tolerance_mm"
+
 // MoveStraight commands a base to move forward for the desired distanceMm at the given mmPerSec.
 // When controls are enabled, MoveStraight calculates the required velocity to reach mmPerSec
 // and the distanceMm goal. It then polls the provided velocity movement sensor and corrects any
@@ -27,9 +32,15 @@ func (sb *sensorBase) MoveStraight(
 	ctx context.Context, distanceMm int, mmPerSec float64, extra map[string]interface{},
 ) error {
 	sb.opMgr.CancelRunning(ctx)
-	ctx, done := sb.opMgr.New(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	ctx, done := sb.newOperation(ctx)
 	defer done()
 
+	if err := sb.checkLevel(ctx); err != nil {
+		return err
+	}
+
 	// If a position movement sensor or controls are not configured, we cannot use this MoveStraight method.
 	// Instead we need to use the MoveStraight method of the base that the sensorcontrolled base wraps.
 	// If there is no valid velocity sensor, there won't be a controlLoopConfig.
@@ -37,9 +48,7 @@ func (sb *sensorBase) MoveStraight(
 		sb.logger.CWarnf(ctx,
 			"control loop not configured, using base %s's MoveStraight",
 			sb.controlledBase.Name().ShortName())
-		if sb.loop != nil {
-			sb.loop.Pause()
-		}
+		sb.pauseControlLoop()
 		return sb.controlledBase.MoveStraight(ctx, distanceMm, mmPerSec, extra)
 	}
 	if sb.position == nil {
@@ -58,6 +67,15 @@ func (sb *sensorBase) MoveStraight(
 		return err
 	}
 
+	tunables, err := sb.motionTunables(extra)
+	if err != nil {
+		return err
+	}
+	overshootTolerance, err := sb.overshootTolerance(extra)
+	if err != nil {
+		return err
+	}
+
 	// make sure the control loop is enabled
 	if sb.loop == nil {
 		if err := sb.startControlLoop(); err != nil {
@@ -65,17 +83,15 @@ func (sb *sensorBase) MoveStraight(
 		}
 	}
 
-	// pause and resume the loop to reset the control blocks.
-	// This prevents any residual signals in the control loop from "kicking" the robot
-	sb.loop.Pause()
-	sb.loop.Resume()
+	sb.resetControlLoop()
 
-	straightTimeEst := time.Duration(int(time.Second) * int(math.Abs(float64(distanceMm)/mmPerSec)))
+	run := sb.startRun(ctx, runMoveStraight, map[string]interface{}{"distance_mm": distanceMm, "mm_per_sec": mmPerSec})
+	defer sb.endRun(run)
+
+	profile := sb.linearProfile()
+	straightTimeEst := time.Duration(int(time.Second)*int(math.Abs(float64(distanceMm)/mmPerSec))) + profile.rampTime(mmPerSec)
 	startTime := time.Now()
-	timeOut := 5 * straightTimeEst
-	if timeOut < 10*time.Second {
-		timeOut = 10 * time.Second
-	}
+	timeOut := tunables.timeout(straightTimeEst)
 
 	// grab the initial heading for MoveStraight to clamp to. Will return 0 if no supporting sensors were configured.
 	initialHeading, _, err := sb.headingFunc(ctx)
@@ -84,15 +100,27 @@ func (sb *sensorBase) MoveStraight(
 	}
 
 	// initialize relevant parameters for moving straight
-	slowDownDist := calcSlowDownDist(distanceMm)
+	slowDownDist := calcSlowDownDist(distanceMm, tunables.straightSlowDownGain, tunables.maxStraightSlowDownMm)
+	// the direction the base moves in while errDist is positive
+	direction := sign(mmPerSec) * sign(slowDownDist)
+	tickSecs := 1. / sb.controlLoopConfig.Frequency
 
-	var initPos *geo.Point
+	var track *alongTrack
 
 	if sb.position != nil {
-		initPos, _, err = sb.position.Position(ctx, nil)
+		track, err = sb.newAlongTrack(ctx, distanceMm, direction)
 		if err != nil {
 			return err
 		}
+		// without a known course the move has to stay inside the geofence whichever way the base goes
+		if track.course != (r3.Vector{}) {
+			target := track.course.Mul(math.Abs(float64(distanceMm)))
+			if err := sb.checkGeofenceTarget(ctx, "MoveStraight", track.start, target); err != nil {
+				return err
+			}
+		} else if err := sb.checkGeofenceReach(ctx, "MoveStraight", track.start, math.Abs(float64(distanceMm))); err != nil {
+			return err
+		}
 	}
 
 	// this state is only used when no position sensor is configured
@@ -104,6 +132,11 @@ func (sb *sensorBase) MoveStraight(
 	for {
 		select {
 		case <-ctx.Done():
+			// a safety check that stopped the base cancels with the fault as the cause
+			var fault *SafetyStopError
+			if errors.As(context.Cause(ctx), &fault) {
+				return fault
+			}
 			// context.cancelled can happen due to UI being closed during MoveStraight.
 			// Do not return context canceled errors, just log them
 			if errors.Is(ctx.Err(), context.Canceled) {
@@ -114,13 +147,13 @@ func (sb *sensorBase) MoveStraight(
 		case <-ticker.C:
 			var errDist float64
 
-			angVelDes, err := sb.calcHeadingControl(ctx, initialHeading)
+			angVelDes, err := sb.calcHeadingControl(ctx, initialHeading, tunables.headingGain)
 			if err != nil {
 				return err
 			}
 
 			if sb.position != nil {
-				errDist, err = sb.calcPositionError(ctx, distanceMm, initPos)
+				errDist, err = sb.calcPositionError(ctx, distanceMm, track)
 				if err != nil {
 					return err
 				}
@@ -138,13 +171,20 @@ func (sb *sensorBase) MoveStraight(
 				prevTime = currTime
 			}
 
-			if errDist < moveStraightErrTarget {
-				return sb.Stop(ctx, nil)
+			sb.history.notePositionErr(errDist)
+
+			// without an overshoot tolerance the base stops as soon as it reaches the goal.
+			// With one, the base keeps correcting, reversing if it went past the goal, until it is within the tolerance.
+			if overshootTolerance == 0 && errDist < moveStraightErrTarget ||
+				overshootTolerance > 0 && math.Abs(errDist) <= overshootTolerance {
+				return sb.finishMoveStraight(ctx, errDist)
 			}
 
-			linVelDes := calcLinVel(errDist, mmPerSec, slowDownDist)
-			if err != nil {
-				return err
+			var linVelDes float64
+			if profile != nil {
+				linVelDes = profile.next(mmPerSec, direction*errDist, tickSecs)
+			} else {
+				linVelDes = calcLinVel(errDist, mmPerSec, slowDownDist)
 			}
 
 			// update velocity controller
@@ -155,35 +195,56 @@ func (sb *sensorBase) MoveStraight(
 			// exit if the straight takes too long
 			if time.Since(startTime) > timeOut {
 				sb.logger.CWarn(ctx, "exceeded time for MoveStraight call, stopping base")
-				return sb.Stop(ctx, nil)
+				return sb.finishMoveStraight(ctx, errDist)
 			}
 		}
 	}
 }
 
+// overshootTolerance returns how far from the goal MoveStraight may finish, or 0 to stop as soon as the goal is reached.
+func (sb *sensorBase) overshootTolerance(extra map[string]interface{}) (float64, error) {
+	raw, ok := extra[overshootToleranceKey]
+	if !ok {
+		return sb.conf.OvershootToleranceMm, nil
+	}
+	tolerance, ok := raw.(float64)
+	if !ok || tolerance < 0 {
+		return 0, fmt.Errorf("extra %s must be a number that is 0 or greater", overshootToleranceKey)
+	}
+	return tolerance, nil
+}
+
+// finishMoveStraight stops the base and records the signed distance it was from the goal,
+// positive when it stopped short and negative when it went past the goal.
+func (sb *sensorBase) finishMoveStraight(ctx context.Context, errDist float64) error {
+	sb.mu.Lock()
+	sb.lastStraightErrMm = &errDist
+	sb.mu.Unlock()
+	sb.logger.CDebugf(ctx, "MoveStraight finished %.1f mm from the goal", errDist)
+	return sb.Stop(ctx, nil)
+}
+
 // calculate the desired angular velocity to correct the heading of the base.
-func (sb *sensorBase) calcHeadingControl(ctx context.Context, initHeading float64) (float64, error) {
+func (sb *sensorBase) calcHeadingControl(ctx context.Context, initHeading, gain float64) (float64, error) {
 	currHeading, _, err := sb.headingFunc(ctx)
 	if err != nil {
 		return 0, err
 	}
+	sb.history.noteHeading(currHeading)
 
-	headingErr := initHeading - currHeading
-	headingErrWrapped := headingErr - (math.Floor((headingErr+180.)/(2*180.)))*2*180. // [-180;180)
-
-	return headingErrWrapped * headingGain, nil
+	return wrapAngleDeg(initHeading-currHeading) * gain, nil
 }
 
 // calcPositionError calculates the current error in position.
-// This results in the distance the base needs to travel to reach the goal.
-func (sb *sensorBase) calcPositionError(ctx context.Context, distanceMm int, initPos *geo.Point) (float64, error) {
-	pos, _, err := sb.position.Position(ctx, nil)
+// This results in the distance the base needs to travel to reach the goal, measured along the course of the base.
+func (sb *sensorBase) calcPositionError(ctx context.Context, distanceMm int, track *alongTrack) (float64, error) {
+	pos, err := sb.readPosition(ctx)
 	if err != nil {
 		return 0, err
 	}
 
-	// the currDist will always return as positive, so we need the goal distanceMm to be positive
-	currDist := initPos.GreatCircleDistance(pos) * 1000000.
+	// progress is measured in the direction of travel, so we need the goal distanceMm to be positive
+	currDist := sb.progressMm(track, pos)
 	return math.Abs(float64(distanceMm)) - currDist, nil
 }
 
@@ -200,10 +261,10 @@ func calcLinVel(errDist, mmPerSec, slowDownDist float64) float64 {
 
 // calcSlowDownDist computes the distance at which the MoveStraight call should begin to slow down.
 // This helps to prevent overshoot when reaching the goal and reduces the jerk on the robot when the straight is complete.
-func calcSlowDownDist(distanceMm int) float64 {
-	slowDownDist := float64(distanceMm) * slowDownDistGain
-	if math.Abs(slowDownDist) > maxSlowDownDist {
-		return maxSlowDownDist * sign(float64(distanceMm))
+func calcSlowDownDist(distanceMm int, gain, maxDist float64) float64 {
+	slowDownDist := float64(distanceMm) * gain
+	if math.Abs(slowDownDist) > maxDist {
+		return maxDist * sign(float64(distanceMm))
 	}
 	return slowDownDist
 }
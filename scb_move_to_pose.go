@@ -0,0 +1,233 @@
+package controlledcomponents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang/geo/r3"
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	moveToPose            = "move_to_pose"
+	defaultPoseMmPerSec   = 200.
+	defaultPoseDegsPerSec = 45.
+	defaultPoseTolMm      = 25.
+	// maxPursuitAngle is how far the target may be off the nose of the base before it turns in place towards it
+	maxPursuitAngle = 60.
+)
+
+// poseRequest holds the options of a move_to_pose DoCommand.
+// The target is an offset from the current pose in the base frame, with Y pointing forward,
+// X pointing right and theta turning counterclockwise.
+type poseRequest struct {
+	xMm, yMm, thetaDeg float64
+	mmPerSec           float64
+	degsPerSec         float64
+	toleranceMm        float64
+}
+
+// parsePoseRequest reads the options of a move_to_pose DoCommand, filling in defaults for any that are missing.
+func parsePoseRequest(raw interface{}) (poseRequest, error) {
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return poseRequest{}, errors.New("move_to_pose requires an object with x_mm, y_mm and theta_deg")
+	}
+	pr := poseRequest{mmPerSec: defaultPoseMmPerSec, degsPerSec: defaultPoseDegsPerSec, toleranceMm: defaultPoseTolMm}
+	fields := []struct {
+		name     string
+		value    *float64
+		positive bool
+	}{
+		{"x_mm", &pr.xMm, false},
+		{"y_mm", &pr.yMm, false},
+		{"theta_deg", &pr.thetaDeg, false},
+		{"mm_per_sec", &pr.mmPerSec, true},
+		{"degs_per_sec", &pr.degsPerSec, true},
+		{"tolerance_mm", &pr.toleranceMm, true},
+	}
+	for _, f := range fields {
+		raw, ok := opts[f.name]
+		if !ok {
+			continue
+		}
+		v, ok := raw.(float64)
+		if !ok {
+			return poseRequest{}, fmt.Errorf("move_to_pose %s must be a number", f.name)
+		}
+		if f.positive && v <= 0 {
+			return poseRequest{}, fmt.Errorf("move_to_pose %s must be greater than 0", f.name)
+		}
+		*f.value = v
+	}
+	return pr, nil
+}
+
+// moveToPose drives the base to a pose relative to where it starts, then turns it to the requested heading.
+// The position is followed with pure pursuit, steering along the arc that ends at the target, and the base turns
+// in place first if the target is far off its nose. The heading is taken from courseHeading, so that it shares
+// the frame of the positions, with a heading of 0 facing +Y (north) and headings increasing counterclockwise.
+// It returns the remaining distance in mm and heading error in degrees.
+func (sb *sensorBase) moveToPose(ctx context.Context, pr poseRequest) (float64, float64, error) {
+	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	ctx, done := sb.newOperation(ctx)
+	defer done()
+
+	if err := sb.checkLevel(ctx); err != nil {
+		return 0, 0, err
+	}
+
+	if sb.controlLoopConfig == nil {
+		return 0, 0, errors.New("move_to_pose requires control_parameters to be configured")
+	}
+	if sb.position == nil {
+		return 0, 0, errors.New("move_to_pose requires a position sensor")
+	}
+	if err := sb.checkTuningStatus(); err != nil {
+		return 0, 0, err
+	}
+	tunables, err := sb.motionTunables(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the target is placed and steered to in the position frame, so the heading has to share its zero
+	startHeading, hasHeading, err := sb.courseHeading(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !hasHeading {
+		return 0, 0, errors.New(
+			"move_to_pose needs a compass in the geodetic frame or odometry orientation in the local frame, " +
+				"as it steers by the heading of the base in the frame of its position")
+	}
+	start, err := sb.readPosition(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	target := rightOf(startHeading).Mul(pr.xMm).Add(forwardOf(startHeading).Mul(pr.yMm))
+	if err := sb.checkGeofenceTarget(ctx, moveToPose, start, target); err != nil {
+		return 0, 0, err
+	}
+
+	if sb.loop == nil {
+		if err := sb.startControlLoop(); err != nil {
+			return 0, 0, err
+		}
+	}
+	sb.resetControlLoop()
+
+	run := sb.startRun(ctx, moveToPose, map[string]interface{}{
+		"x_mm": pr.xMm, "y_mm": pr.yMm, "theta_deg": pr.thetaDeg,
+		"mm_per_sec": pr.mmPerSec, "degs_per_sec": pr.degsPerSec, "tolerance_mm": pr.toleranceMm,
+	})
+	defer sb.endRun(run)
+
+	targetHeading := startHeading + pr.thetaDeg
+	slowDownDist := math.Abs(calcSlowDownDist(int(target.Norm()), tunables.straightSlowDownGain, tunables.maxStraightSlowDownMm))
+	slowDownAng := calcSlowDownAng(pr.thetaDeg, tunables.spinSlowDownGain, tunables.maxSpinSlowDownDeg)
+	// the base may have to turn towards the target and then back to the final heading
+	timeEst := time.Duration((target.Norm()/pr.mmPerSec + (180+math.Abs(pr.thetaDeg))/pr.degsPerSec) * float64(time.Second))
+	timeOut := tunables.timeout(timeEst)
+	startTime := time.Now()
+
+	ticker := time.NewTicker(time.Duration(1000./sb.controlLoopConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+	var distErr, headingErr float64
+	arrived := false
+	for {
+		select {
+		case <-ctx.Done():
+			// a safety check that stopped the base cancels with the fault as the cause
+			var fault *SafetyStopError
+			if errors.As(context.Cause(ctx), &fault) {
+				return distErr, headingErr, fault
+			}
+			// context.cancelled can happen due to UI being closed during move_to_pose.
+			// Do not return context canceled errors, just log them
+			if errors.Is(ctx.Err(), context.Canceled) {
+				sb.logger.CWarnf(ctx, "Context cancelled during move_to_pose: %v", ctx.Err())
+				return distErr, headingErr, nil
+			}
+			return distErr, headingErr, ctx.Err()
+		case <-ticker.C:
+		}
+
+		heading, _, err := sb.courseHeading(ctx)
+		if err != nil {
+			return distErr, headingErr, err
+		}
+		pos, err := sb.readPosition(ctx)
+		if err != nil {
+			return distErr, headingErr, err
+		}
+		toTarget := target.Sub(sb.displacementMm(start, pos))
+		distErr = toTarget.Norm()
+		headingErr = wrapAngleDeg(targetHeading - heading)
+		sb.history.noteHeading(heading)
+		sb.history.notePositionErr(distErr)
+
+		// once the base reaches the position it only aligns its heading, so small position noise does not restart the drive
+		arrived = arrived || distErr <= pr.toleranceMm
+		var linVel, angVel float64
+		if !arrived {
+			linVel, angVel = purePursuit(toTarget, heading, pr.mmPerSec, pr.degsPerSec, slowDownDist)
+		} else {
+			if math.Abs(headingErr) < tunables.spinToleranceDeg {
+				return distErr, headingErr, sb.Stop(ctx, nil)
+			}
+			angVel = calcAngVel(headingErr, pr.degsPerSec, math.Max(slowDownAng, tunables.spinToleranceDeg))
+		}
+
+		if err := sb.updateControlConfig(ctx, linVel/1000.0, angVel); err != nil {
+			return distErr, headingErr, err
+		}
+
+		if time.Since(startTime) > timeOut {
+			sb.logger.CWarn(ctx, "exceeded time for move_to_pose, stopping base")
+			return distErr, headingErr, sb.Stop(ctx, nil)
+		}
+	}
+}
+
+// purePursuit returns the linear velocity in mm/s and angular velocity in deg/s that steer the base along
+// the arc through the target. If the target is too far off the nose of the base it turns in place instead.
+func purePursuit(toTarget r3.Vector, heading, mmPerSec, degsPerSec, slowDownDist float64) (float64, float64) {
+	dist := toTarget.Norm()
+	// the angle from the nose of the base to the target, positive to the left
+	alpha := rdkutils.RadToDeg(math.Atan2(-toTarget.Dot(rightOf(heading)), toTarget.Dot(forwardOf(heading))))
+	if math.Abs(alpha) > maxPursuitAngle {
+		return 0, calcAngVel(alpha, degsPerSec, maxPursuitAngle)
+	}
+	linVel := calcLinVel(dist, mmPerSec, math.Max(slowDownDist, 1))
+	// the curvature of the arc through the target is 2*sin(alpha)/dist
+	angVel := rdkutils.RadToDeg(linVel * 2 * math.Sin(rdkutils.DegToRad(alpha)) / dist)
+	if math.Abs(angVel) > degsPerSec {
+		// slow down rather than leave the arc when the turn is too tight
+		linVel *= degsPerSec / math.Abs(angVel)
+		angVel = degsPerSec * sign(angVel)
+	}
+	return linVel, angVel
+}
+
+// forwardOf returns the unit vector the base faces at a heading.
+func forwardOf(headingDeg float64) r3.Vector {
+	h := rdkutils.DegToRad(headingDeg)
+	return r3.Vector{X: -math.Sin(h), Y: math.Cos(h)}
+}
+
+// rightOf returns the unit vector to the right of the base at a heading.
+func rightOf(headingDeg float64) r3.Vector {
+	h := rdkutils.DegToRad(headingDeg)
+	return r3.Vector{X: math.Cos(h), Y: math.Sin(h)}
+}
+
+// wrapAngleDeg wraps an angle to [-180, 180).
+func wrapAngleDeg(angle float64) float64 {
+	return angle - math.Floor((angle+180.)/360.)*360.
+}
@@ -2,6 +2,7 @@ package controlledcomponents
 
 import (
 	"context"
+	"time"
 
 	"github.com/golang/geo/r3"
 	"go.viam.com/rdk/control"
@@ -18,8 +19,66 @@ func (sb *sensorBase) startControlLoop() error {
 		return err
 	}
 	sb.loop = loop
+	sb.schedule.reset()
+	sb.saturation.resetIntegrators()
+	sb.noteIntegralGains()
+	sb.safety.resetChecks()
 
-	return nil
+	return sb.startLateralLoop()
+}
+
+// stopControlLoop stops every running control loop of the base. The loops are taken off the base under sb.mu
+// and stopped once it is released, as a stopping loop waits for a SetState that may be waiting on sb.mu.
+// The caller must not hold sb.mu.
+func (sb *sensorBase) stopControlLoop() {
+	sb.mu.Lock()
+	loop := sb.loop
+	sb.loop = nil
+	var lateralLoop *control.Loop
+	if sb.lateral != nil {
+		lateralLoop = sb.lateral.loop
+		sb.lateral.loop = nil
+	}
+	sb.mu.Unlock()
+
+	if loop != nil {
+		loop.Stop()
+	}
+	if lateralLoop != nil {
+		lateralLoop.Stop()
+	}
+}
+
+// pauseControlLoop pauses the control loops so that their outputs are no longer sent to the base.
+func (sb *sensorBase) pauseControlLoop() {
+	if sb.loop != nil {
+		sb.loop.Pause()
+	}
+	if sb.lateral != nil && sb.lateral.loop != nil {
+		sb.lateral.loop.Pause()
+	}
+}
+
+// resumeControlLoop resumes the control loops, resetting the PID blocks of any paused loop.
+func (sb *sensorBase) resumeControlLoop() {
+	if sb.loop != nil {
+		if !sb.loop.Running() {
+			sb.saturation.resetIntegrators()
+			sb.safety.resetChecks()
+		}
+		sb.loop.Resume()
+	}
+	if sb.lateral != nil && sb.lateral.loop != nil {
+		sb.lateral.loop.Resume()
+	}
+}
+
+// resetControlLoop resets the control blocks of the running loops, so that residual signals left by the last
+// command do not "kick" the robot when the next one starts. The control package only resets its PID blocks
+// when a paused loop resumes, so the loops are paused and resumed straight away.
+func (sb *sensorBase) resetControlLoop() {
+	sb.pauseControlLoop()
+	sb.resumeControlLoop()
 }
 
 func (sb *sensorBase) setupControlLoop(linear, angular control.PIDConfig) error {
@@ -48,13 +107,35 @@ func (sb *sensorBase) setupControlLoop(linear, angular control.PIDConfig) error
 	sb.loop = pl.ControlLoop
 	sb.blockNames = pl.BlockNames
 	sb.tunedVals = pl.TunedVals
+	sb.schedule.reset()
+	sb.saturation.resetIntegrators()
+	sb.noteIntegralGains()
+	sb.safety.resetChecks()
 
 	return nil
 }
 
+// updateControlConfig sets the linear and angular setpoints of the control loop, holding any lateral velocity at zero.
 func (sb *sensorBase) updateControlConfig(
 	ctx context.Context, linearValue, angularValue float64,
 ) error {
+	return sb.updateVelocitySetpoints(ctx, 0, linearValue, angularValue)
+}
+
+// updateVelocitySetpoints sets the lateral and linear setpoints in m/s and the angular setpoint in deg/s.
+func (sb *sensorBase) updateVelocitySetpoints(
+	ctx context.Context, lateralValue, linearValue, angularValue float64,
+) error {
+	// the gains are switched before the setpoints are noted, as the switch carries over the output of the last setpoints
+	if err := sb.scheduleGains(ctx, 0, linearValue); err != nil {
+		return err
+	}
+	if err := sb.scheduleGains(ctx, 1, angularValue); err != nil {
+		return err
+	}
+	sb.history.noteSetpoints(linearValue, angularValue)
+	sb.ff.setSetpoint(0, linearValue)
+	sb.ff.setSetpoint(1, angularValue)
 	// set linear setpoint config
 	if err := control.UpdateConstantBlock(ctx, sb.blockNames[control.BlockNameConstant][0], linearValue, sb.loop); err != nil {
 		return err
@@ -65,7 +146,17 @@ func (sb *sensorBase) updateControlConfig(
 		return err
 	}
 
-	return nil
+	return sb.updateLateralSetpoint(ctx, lateralValue)
+}
+
+// updateAngularSetpoint sets the angular setpoint in deg/s, leaving the other setpoints as they are.
+func (sb *sensorBase) updateAngularSetpoint(ctx context.Context, angularValue float64) error {
+	if err := sb.scheduleGains(ctx, 1, angularValue); err != nil {
+		return err
+	}
+	sb.history.noteAngularSetpoint(angularValue)
+	sb.ff.setSetpoint(1, angularValue)
+	return control.UpdateConstantBlock(ctx, sb.blockNames[control.BlockNameConstant][1], angularValue, sb.loop)
 }
 
 // SetState is called in endpoint.go of the controls package by the control loop
@@ -80,12 +171,37 @@ func (sb *sensorBase) SetState(ctx context.Context, state []*control.Signal) err
 	}
 
 	sb.logger.CDebug(ctx, "setting state")
-	linvel := state[0].GetSignalValueAt(0)
+	linPID, angPID := state[0].GetSignalValueAt(0), state[1].GetSignalValueAt(0)
+	// feedforward and gain scheduling are only applied to the live loop, the loops that tune the PIDs must see the base on its own
+	var linFF, angFF float64
+	var current controlSample
+	if sb.loop != nil {
+		now := time.Now()
+		linFF, angFF = sb.ff.output(now)
+		linPID = sb.schedule.transfer(0, linPID, now)
+		angPID = sb.schedule.transfer(1, angPID, now)
+
+		// the errors the PIDs acted on, from the latest setpoints and measurements
+		current, _ = sb.history.snapshot(0)
+		dt := 1 / sb.controlFreq
+		linPID = sb.saturation.holdWindup(0, linPID, linFF, current.linearSetpoint-current.linearMeasured, dt)
+		angPID = sb.saturation.holdWindup(1, angPID, angFF, current.angularSetpoint-current.angularMeasured, dt)
+	}
+	// the deadband is only applied to the live loop, the setpoints of the tuning loops are not noted
+	linvel := applyDeadband(linPID+linFF, sb.deadband[0], current.linearSetpoint)
 	// multiply by the direction of the linear velocity so that angular direction
 	// (cw/ccw) doesn't switch when the base is moving backwards
-	angvel := (state[1].GetSignalValueAt(0) * sign(linvel))
+	angvel := (applyDeadband(angPID+angFF, sb.deadband[1], current.angularSetpoint) * sign(linvel))
+	powers := sb.limitPowers(ctx, [3]float64{linvel, angvel, sb.lateralPower()})
+	// the safety checks also leave the loops that tune the PIDs alone
+	if sb.loop != nil && !sb.tuningInProgress() {
+		if stopped, err := sb.checkSafety(ctx, powers, current); stopped {
+			return err
+		}
+	}
+	sb.history.record(powers[0], powers[1])
 
-	return sb.controlledBase.SetPower(ctx, r3.Vector{Y: linvel}, r3.Vector{Z: angvel}, nil)
+	return sb.controlledBase.SetPower(ctx, r3.Vector{X: powers[2], Y: powers[0]}, r3.Vector{Z: powers[1]}, nil)
 }
 
 // State is called in endpoint.go of the controls package by the control loop
@@ -103,6 +219,9 @@ func (sb *sensorBase) State(ctx context.Context) ([]float64, error) {
 	if err != nil {
 		return []float64{}, err
 	}
+	sb.history.noteMeasured(linvel.Y, angvel.Z)
+	sb.noteGeofence(ctx)
+	sb.noteTilt(ctx)
 	return []float64{linvel.Y, angvel.Z}, nil
 }
 
@@ -0,0 +1,346 @@
+package controlledcomponents
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.viam.com/rdk/control"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/utils"
+)
+
+const (
+	runLogCSV              = "csv"
+	runLogJSON             = "json"
+	defaultRunLogMaxFiles  = 20
+	defaultRunLogMaxFileMB = 5.
+	runLogDirPermissions   = 0o700
+	runLogTimeFormat       = "20060102T150405.000Z"
+	// runLogQueueSize is how many samples can wait to be written, a few seconds of the run at the default frequency
+	runLogQueueSize = 64
+
+	// the kinds of run, along with moveToPose and followWaypoints
+	runMoveStraight = "move_straight"
+	runSpin         = "spin"
+	runSetVelocity  = "set_velocity"
+	runTuning       = "tuning"
+)
+
+// runLogColumns are the per-tick signals written for each sample, in the order of the CSV columns.
+var runLogColumns = []string{
+	"time",
+	"linear_setpoint_m_per_s", "angular_setpoint_deg_per_s",
+	"linear_measured_m_per_s", "angular_measured_deg_per_s",
+	"linear_output", "angular_output",
+	"heading_deg", "position_error_mm",
+}
+
+// runLogConfig is where and how runs of the base are written, taken from the run_log attributes.
+type runLogConfig struct {
+	dir      string
+	format   string
+	maxFiles int
+	maxBytes int64
+	base     string
+	sensors  []string
+}
+
+// newRunLogConfig returns the run log settings of a config, with defaults for any that are missing.
+// Runs are only written when run_log_dir is set.
+func newRunLogConfig(conf *SCBConfig) runLogConfig {
+	rc := runLogConfig{
+		dir:      conf.RunLogDir,
+		format:   conf.RunLogFormat,
+		maxFiles: conf.RunLogMaxFiles,
+		maxBytes: int64(conf.RunLogMaxFileMB * 1024 * 1024),
+		base:     conf.Base,
+		sensors:  conf.MovementSensor,
+	}
+	if rc.format == "" {
+		rc.format = runLogCSV
+	}
+	if rc.maxFiles == 0 {
+		rc.maxFiles = defaultRunLogMaxFiles
+	}
+	if rc.maxBytes == 0 {
+		rc.maxBytes = int64(defaultRunLogMaxFileMB * 1024 * 1024)
+	}
+	return rc
+}
+
+// runLog writes the samples of one run of the base to a file as the control loop records them.
+// Once the file reaches its size limit the remaining samples are dropped and the run is marked truncated.
+// When run logging is disabled the run has no file and only tracks which kind of operation is running.
+type runLog struct {
+	mu        sync.Mutex
+	kind      string
+	path      string
+	format    string
+	file      *os.File
+	w         *bufio.Writer
+	maxBytes  int64
+	written   int64
+	samples   int
+	truncated bool
+	logger    logging.Logger
+	// samples reach the file through queue, so the control loop never waits on the disk
+	queue   chan controlSample
+	done    chan struct{}
+	dropped int
+}
+
+// startRun ends the run being recorded and starts a new one, writing it to a file if run logging is enabled.
+// The run is tracked even when it is not written, so that diagnostics can report the active operation.
+// request holds the arguments of the command that started the run. A run that cannot be written
+// only logs a warning, so it never stops the base from moving.
+func (sb *sensorBase) startRun(ctx context.Context, kind string, request map[string]interface{}) *runLog {
+	sb.stopRun()
+	run := &runLog{kind: kind}
+	if rc := sb.runLogs; rc.dir != "" {
+		start := time.Now().UTC()
+		metadata := map[string]interface{}{
+			"name":                 sb.Name().ShortName(),
+			"run":                  kind,
+			"start_time":           start.Format(time.RFC3339Nano),
+			"control_frequency_hz": sb.controlFreq,
+			"gains":                sb.runGains(),
+			"base":                 rc.base,
+			"movement_sensors":     rc.sensors,
+			"request":              request,
+		}
+		fileName := fmt.Sprintf("%s_%s_%s.%s", sb.Name().ShortName(), start.Format(runLogTimeFormat), kind, rc.format)
+		written, err := createRunLog(rc, sb.Name().ShortName(), kind, filepath.Join(rc.dir, fileName), metadata, sb.logger)
+		if err != nil {
+			sb.logger.CWarnf(ctx, "could not write %s run to %s: %v", kind, rc.dir, err)
+		} else {
+			sb.logger.CDebugf(ctx, "writing %s run to %s", kind, written.path)
+			run = written
+		}
+	}
+	sb.history.attachRun(run)
+	return run
+}
+
+// endRun finishes writing run, if it is still being recorded.
+func (sb *sensorBase) endRun(run *runLog) {
+	if run == nil {
+		return
+	}
+	if sb.history.detachRun(run) != nil {
+		run.close()
+	}
+}
+
+// stopRun finishes writing whichever run is being recorded, such as a SetVelocity session that a Stop ended.
+func (sb *sensorBase) stopRun() {
+	if run := sb.history.detachRun(nil); run != nil {
+		run.close()
+	}
+}
+
+// runGains returns the gains the control loops are running with.
+func (sb *sensorBase) runGains() []control.PIDConfig {
+	gains := append([]control.PIDConfig{}, sb.configPIDVals...)
+	if sb.lateral != nil {
+		if !sb.lateral.tuned.NeedsAutoTuning() {
+			gains = append(gains, sb.lateral.tuned)
+		} else {
+			gains = append(gains, sb.lateral.conf)
+		}
+	}
+	return gains
+}
+
+// createRunLog creates the file for a run and writes its metadata, removing the oldest runs
+// of the base so that no more than the configured number of its files are kept.
+func createRunLog(
+	rc runLogConfig, name, kind, path string, metadata map[string]interface{}, logger logging.Logger,
+) (*runLog, error) {
+	if err := os.MkdirAll(rc.dir, runLogDirPermissions); err != nil {
+		return nil, err
+	}
+	if err := pruneRunLogs(rc.dir, name, rc.maxFiles-1); err != nil {
+		logger.Warnf("could not remove old runs from %s: %v", rc.dir, err)
+	}
+	file, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	run := &runLog{kind: kind, path: path, format: rc.format, file: file, maxBytes: rc.maxBytes, logger: logger}
+	run.w = bufio.NewWriter(run)
+
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		run.file.Close()
+		return nil, err
+	}
+	switch rc.format {
+	case runLogJSON:
+		_, err = fmt.Fprintf(run.w, "{\"metadata\":%s,\"samples\":[", meta)
+	default:
+		// the metadata is a comment line, so it can be skipped with pandas' comment="#"
+		_, err = fmt.Fprintf(run.w, "# %s\n%s\n", meta, strings.Join(runLogColumns, ","))
+	}
+	if err != nil {
+		run.file.Close()
+		return nil, err
+	}
+	run.queue = make(chan controlSample, runLogQueueSize)
+	run.done = make(chan struct{})
+	utils.PanicCapturingGo(func() {
+		defer close(run.done)
+		for sample := range run.queue {
+			run.write(sample)
+		}
+	})
+	return run, nil
+}
+
+// enqueue hands a sample to the goroutine writing the run without blocking. The sample is dropped
+// if the disk has fallen too far behind, and the number dropped is logged when the run is closed.
+// It must not be called once the run is closed, which holds as long as the run is detached from the history first.
+func (run *runLog) enqueue(sample controlSample) {
+	if run.queue == nil {
+		return
+	}
+	select {
+	case run.queue <- sample:
+	default:
+		run.dropped++
+	}
+}
+
+// Write counts the bytes written to the file so that the size limit can be enforced.
+func (run *runLog) Write(p []byte) (int, error) {
+	n, err := run.file.Write(p)
+	run.written += int64(n)
+	return n, err
+}
+
+// write adds a sample to the run, dropping it if the file has reached its size limit.
+func (run *runLog) write(sample controlSample) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.file == nil || run.truncated {
+		return
+	}
+	if run.written+int64(run.w.Buffered()) >= run.maxBytes {
+		run.truncated = true
+		run.logger.Warnf("run file %s reached its size limit, the rest of the run is not written", run.path)
+		return
+	}
+
+	var err error
+	switch run.format {
+	case runLogJSON:
+		var data []byte
+		data, err = json.Marshal(sample.toMap())
+		if err == nil {
+			if run.samples > 0 {
+				err = run.w.WriteByte(',')
+			}
+			if err == nil {
+				_, err = fmt.Fprintf(run.w, "\n%s", data)
+			}
+		}
+	default:
+		_, err = fmt.Fprintln(run.w, strings.Join(sample.toRecord(), ","))
+	}
+	if err != nil {
+		run.truncated = true
+		run.logger.Warnf("could not write to run file %s: %v", run.path, err)
+		return
+	}
+	run.samples++
+}
+
+// close waits for the queued samples to be written, then finishes the run, noting when it ended
+// and whether it was truncated, and closes the file.
+func (run *runLog) close() {
+	if run.queue != nil {
+		close(run.queue)
+		<-run.done
+		if run.dropped > 0 {
+			run.logger.Warnf("%d samples were dropped from run file %s because writing them fell behind", run.dropped, run.path)
+		}
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.file == nil {
+		return
+	}
+	end := time.Now().UTC().Format(time.RFC3339Nano)
+	var err error
+	switch run.format {
+	case runLogJSON:
+		_, err = fmt.Fprintf(run.w, "\n],\"end_time\":%q,\"truncated\":%t}\n", end, run.truncated)
+	default:
+		_, err = fmt.Fprintf(run.w, "# {\"end_time\":%q,\"truncated\":%t}\n", end, run.truncated)
+	}
+	if err == nil {
+		err = run.w.Flush()
+	}
+	if closeErr := run.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		run.logger.Warnf("could not finish run file %s: %v", run.path, err)
+	}
+	run.file = nil
+}
+
+// pruneRunLogs removes the oldest run files of the named base in dir until at most keep are left.
+// Run file names are the base name followed by the time the run started, so they sort in the order they were written.
+func pruneRunLogs(dir, name string, keep int) error {
+	var runs []string
+	for _, format := range []string{runLogCSV, runLogJSON} {
+		matches, err := filepath.Glob(filepath.Join(dir, name+"_*."+format))
+		if err != nil {
+			return err
+		}
+		for _, path := range matches {
+			// skip the runs of other bases whose names start with this one
+			stamp := strings.TrimPrefix(filepath.Base(path), name+"_")
+			if len(stamp) < len(runLogTimeFormat) {
+				continue
+			}
+			if _, err := time.Parse(runLogTimeFormat, stamp[:len(runLogTimeFormat)]); err == nil {
+				runs = append(runs, path)
+			}
+		}
+	}
+	if len(runs) <= keep {
+		return nil
+	}
+	sort.Strings(runs)
+	for _, path := range runs[:len(runs)-keep] {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// toRecord converts the sample into a row of a CSV run file.
+func (s controlSample) toRecord() []string {
+	values := []float64{
+		s.linearSetpoint, s.angularSetpoint,
+		s.linearMeasured, s.angularMeasured,
+		s.linearOutput, s.angularOutput,
+		s.headingDeg, s.positionErrMm,
+	}
+	record := []string{s.time.Format(time.RFC3339Nano)}
+	for _, v := range values {
+		record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return record
+}
@@ -0,0 +1,378 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	getSafetyStatus = "get_safety_status"
+	safetyStall     = "stall"
+	// the defaults of the stall detector, which is enabled by stall_window_sec
+	defaultStallPower             = 0.5
+	defaultStallLinearMmPerSec    = 10.
+	defaultStallAngularDegsPerSec = 2.
+)
+
+// ErrStalled matches, with errors.Is, the error a command returns when the stall detector stopped the base.
+var ErrStalled = &SafetyStopError{Check: safetyStall}
+
+// SafetyStopError is returned by a command when a safety check of the control loop stopped the base.
+type SafetyStopError struct {
+	Check  string
+	Detail string
+	Time   time.Time
+}
+
+func (e *SafetyStopError) Error() string {
+	return fmt.Sprintf("%s check stopped the base: %s", e.Check, e.Detail)
+}
+
+// Is matches any SafetyStopError of the same check, so that errors can be compared to ErrStalled.
+func (e *SafetyStopError) Is(target error) bool {
+	t, ok := target.(*SafetyStopError)
+	return ok && t.Check == e.Check
+}
+
+// stallConfig holds the settings of the stall detector. A zero window disables it.
+type stallConfig struct {
+	window            time.Duration
+	power             float64
+	linearMmPerSec    float64
+	angularDegsPerSec float64
+}
+
+// newStallConfig returns the stall detector settings of a config, with defaults for any that are missing.
+func newStallConfig(conf *SCBConfig) stallConfig {
+	sc := stallConfig{
+		window:            time.Duration(conf.StallWindowSec * float64(time.Second)),
+		power:             conf.StallPower,
+		linearMmPerSec:    conf.StallLinearMmPerSec,
+		angularDegsPerSec: conf.StallAngularDegsPerSec,
+	}
+	if sc.power == 0 {
+		sc.power = defaultStallPower
+	}
+	if sc.linearMmPerSec == 0 {
+		sc.linearMmPerSec = defaultStallLinearMmPerSec
+	}
+	if sc.angularDegsPerSec == 0 {
+		sc.angularDegsPerSec = defaultStallAngularDegsPerSec
+	}
+	return sc
+}
+
+// safetyConfig holds the settings of the safety checks.
+type safetyConfig struct {
+	stall stallConfig
+	// commandTimeout is how long a SetVelocity session may go without a new command, zero if it may run forever
+	commandTimeout time.Duration
+	staleSensors   []StaleSensorConfig
+	geofence       bool
+	tilt           tiltConfig
+}
+
+// newSafetyConfig returns the safety settings of a config, with defaults for any that are missing.
+func newSafetyConfig(conf *SCBConfig) safetyConfig {
+	return safetyConfig{
+		stall:          newStallConfig(conf),
+		commandTimeout: time.Duration(conf.VelocityCommandTimeout * float64(time.Second)),
+		staleSensors:   conf.StaleSensor,
+		geofence:       conf.Geofence != nil,
+		tilt:           tiltConfig{maxRollDeg: conf.MaxRollDeg, maxPitchDeg: conf.MaxPitchDeg},
+	}
+}
+
+// safetyMonitor runs the safety checks of the live control loop and keeps track of the faults they raise.
+// A fault cancels the running operation with the fault as the cause, so that the command returns it.
+// A fault raised while no operation is running, such as after SetVelocity has returned,
+// is returned by the next SetVelocity instead.
+type safetyMonitor struct {
+	mu   sync.Mutex
+	conf safetyConfig
+	// stallSince is when each of the linear and angular axes started pushing without moving, zero if it is not
+	stallSince [2]time.Time
+	// stale holds the staleness check of each checked sensor role, and poweredAt is when the base was last driven
+	stale     map[string]*staleCheck
+	poweredAt time.Time
+	// outsideMm is how far outside the geofence the base last was, and closestMm is the closest it has been
+	// since the control loop resumed or since it left the fence, negative if it has not been read yet
+	outsideMm float64
+	closestMm float64
+	// cancelOp cancels the running operation, opID tells operations apart so that a finished one does not unwatch the next
+	cancelOp context.CancelCauseFunc
+	opID     int
+	// lastFault is the latest fault, and unreported is whether no command has returned it yet
+	lastFault  *SafetyStopError
+	unreported bool
+	// watchdogFired is whether the command watchdog stopped the current SetVelocity session,
+	// and watchdogTime is when it last stopped one
+	watchdogFired bool
+	watchdogTime  time.Time
+	// rollDeg and pitchDeg are the latest tilt of the base, tiltRead is whether it has been read since the last
+	// configure, and tilted is whether the base went past its limits and has not been level since
+	rollDeg  float64
+	pitchDeg float64
+	tiltRead bool
+	tilted   bool
+}
+
+// configure applies new safety settings and forgets the state of the checks.
+func (s *safetyMonitor) configure(conf safetyConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conf = conf
+	s.stallSince = [2]time.Time{}
+	s.stale = newStaleChecks(conf.staleSensors)
+	s.poweredAt = time.Time{}
+	s.outsideMm, s.closestMm = 0, -1
+	// a tilted base stays tilted until it is level, unless the limits are gone
+	s.tiltRead = false
+	s.tilted = s.tilted && conf.tilt.enabled()
+}
+
+// resetChecks forgets the state of the checks, for when the control loop starts or resumes from a pause.
+func (s *safetyMonitor) resetChecks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stallSince = [2]time.Time{}
+	s.stale = newStaleChecks(s.conf.staleSensors)
+	s.poweredAt = time.Time{}
+	// a base outside the geofence may be driven back in from wherever it is
+	s.closestMm = -1
+}
+
+// checkStall advances the stall timers of the linear and angular axes with the powers sent to the base and
+// the velocities measured, in mm/s and deg/s. It returns a fault once an axis has pushed with at least the stall
+// power and moved slower than its threshold for the whole stall window.
+func (s *safetyMonitor) checkStall(powers, measured [2]float64, now time.Time) *SafetyStopError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stall := s.conf.stall
+	if stall.window == 0 {
+		return nil
+	}
+	thresholds := [2]float64{stall.linearMmPerSec, stall.angularDegsPerSec}
+	for axis := range s.stallSince {
+		if math.Abs(powers[axis]) < stall.power || math.Abs(measured[axis]) >= thresholds[axis] {
+			s.stallSince[axis] = time.Time{}
+			continue
+		}
+		if s.stallSince[axis].IsZero() {
+			s.stallSince[axis] = now
+			continue
+		}
+		if stalled := now.Sub(s.stallSince[axis]); stalled >= stall.window {
+			s.stallSince = [2]time.Time{}
+			return &SafetyStopError{
+				Check: safetyStall,
+				Detail: fmt.Sprintf("%s power stayed at %.2f while the base moved at %.2f for %v",
+					axisName(axis), powers[axis], measured[axis], stalled.Round(time.Millisecond)),
+				Time: now,
+			}
+		}
+	}
+	return nil
+}
+
+// noteOutside records how far outside the geofence the base is, in mm.
+func (s *safetyMonitor) noteOutside(outsideMm float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outsideMm = outsideMm
+	if outsideMm == 0 {
+		// a base that leaves the fence is measured from the fence
+		s.closestMm = 0
+		return
+	}
+	if s.closestMm < 0 || outsideMm < s.closestMm {
+		s.closestMm = outsideMm
+	}
+}
+
+// checkGeofence returns a fault as soon as a base that was inside the geofence leaves it. A base that was already
+// outside may still be driven back in, and is only stopped once it has moved more than marginMm further from
+// the fence than the closest it has been.
+func (s *safetyMonitor) checkGeofence(marginMm float64, now time.Time) *SafetyStopError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outsideMm == 0 || s.closestMm < 0 {
+		return nil
+	}
+	detail := fmt.Sprintf("the base left the geofence and is %.2f m outside it", s.outsideMm/1000)
+	if s.closestMm > 0 {
+		if s.outsideMm-s.closestMm <= marginMm {
+			return nil
+		}
+		detail = fmt.Sprintf("the base is %.1f m outside the geofence and moving away from it", s.outsideMm/1000)
+	}
+	s.closestMm = -1
+	return &SafetyStopError{Check: safetyGeofence, Detail: detail, Time: now}
+}
+
+// commandTimeout returns how long a SetVelocity session may go without a new command, zero if it may run forever.
+func (s *safetyMonitor) commandTimeout() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conf.commandTimeout
+}
+
+// noteCommand records that a new SetVelocity arrived, so the watchdog has not stopped its session.
+func (s *safetyMonitor) noteCommand() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchdogFired = false
+}
+
+// noteWatchdog records that the command watchdog stopped a SetVelocity session at now.
+func (s *safetyMonitor) noteWatchdog(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchdogFired = true
+	s.watchdogTime = now
+}
+
+// watch registers the cancel function of a new operation and returns its id.
+func (s *safetyMonitor) watch(cancel context.CancelCauseFunc) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opID++
+	s.cancelOp = cancel
+	return s.opID
+}
+
+// unwatch forgets a finished operation, unless another has already replaced it.
+func (s *safetyMonitor) unwatch(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opID == id {
+		s.cancelOp = nil
+	}
+}
+
+// trip records a fault and cancels the running operation with it, or keeps it for the next SetVelocity
+// if no operation is running.
+func (s *safetyMonitor) trip(fault *SafetyStopError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFault = fault
+	if s.cancelOp != nil {
+		s.cancelOp(fault)
+		s.cancelOp = nil
+		s.unreported = false
+		return
+	}
+	s.unreported = true
+}
+
+// takeUnreported returns the latest fault if no command has returned it yet, and marks it returned.
+func (s *safetyMonitor) takeUnreported() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.unreported {
+		return nil
+	}
+	s.unreported = false
+	return s.lastFault
+}
+
+// clearUnreported marks the latest fault returned, for when a command has stopped the base anyway.
+func (s *safetyMonitor) clearUnreported() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unreported = false
+}
+
+// status returns the state of the safety checks for the get_safety_status DoCommand.
+func (s *safetyMonitor) status() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := map[string]interface{}{
+		"stall_detection":              s.conf.stall.window != 0,
+		"velocity_command_timeout_sec": s.conf.commandTimeout.Seconds(),
+		"watchdog_fired":               s.watchdogFired,
+	}
+	staleSensors := []interface{}{}
+	for _, conf := range s.conf.staleSensors {
+		staleSensors = append(staleSensors, conf.Type)
+	}
+	status["stale_sensor_checks"] = staleSensors
+	status["geofence"] = s.conf.geofence
+	if s.conf.geofence {
+		status["outside_geofence_m"] = s.outsideMm / 1000
+	}
+	if s.conf.tilt.enabled() {
+		status["max_roll_deg"] = s.conf.tilt.maxRollDeg
+		status["max_pitch_deg"] = s.conf.tilt.maxPitchDeg
+		status["tilted"] = s.tilted
+		if s.tiltRead {
+			status["roll_deg"] = s.rollDeg
+			status["pitch_deg"] = s.pitchDeg
+		}
+	}
+	if !s.watchdogTime.IsZero() {
+		status["last_watchdog_time"] = s.watchdogTime.Format(time.RFC3339Nano)
+	}
+	if s.lastFault != nil {
+		status["last_fault"] = map[string]interface{}{
+			"check":   s.lastFault.Check,
+			"message": s.lastFault.Error(),
+			"time":    s.lastFault.Time.Format(time.RFC3339Nano),
+		}
+	}
+	return status
+}
+
+// newOperation starts a new operation on the operation manager that the safety checks can stop.
+// When a fault stops it, context.Cause of the returned context is the fault.
+func (sb *sensorBase) newOperation(ctx context.Context) (context.Context, func()) {
+	ctx, done := sb.opMgr.New(ctx)
+	ctx, cancel := context.WithCancelCause(ctx)
+	id := sb.safety.watch(cancel)
+	return ctx, func() {
+		sb.safety.unwatch(id)
+		cancel(nil)
+		done()
+	}
+}
+
+// safetyStop pauses the control loops and stops the base for a fault raised by a safety check,
+// then hands the fault to the running operation. The caller must hold sb.mu, so the command
+// that is stopped is left to clean up after itself.
+func (sb *sensorBase) safetyStop(ctx context.Context, fault *SafetyStopError) error {
+	sb.logger.CErrorf(ctx, "%v", fault)
+	sb.pauseControlLoop()
+	// the base is stopped before the operation is cancelled, as ctx may be the context of the operation
+	err := sb.controlledBase.Stop(ctx, nil)
+	sb.safety.trip(fault)
+	return err
+}
+
+// checkSafety runs the safety checks of the live control loop on the powers about to be sent to the base and
+// the latest sample of the loop, and stops the base if one of them fails. It returns whether the base was stopped.
+// The caller must hold sb.mu.
+func (sb *sensorBase) checkSafety(ctx context.Context, powers [3]float64, current controlSample) (bool, error) {
+	now := time.Now()
+	sb.safety.notePower(powers, now)
+	measured := [2]float64{current.linearMeasured * 1000, current.angularMeasured}
+	if fault := sb.safety.checkStall([2]float64{powers[0], powers[1]}, measured, now); fault != nil {
+		return true, sb.safetyStop(ctx, fault)
+	}
+	period := time.Duration(float64(time.Second) / sb.controlFreq)
+	velocities := []float64{current.linearMeasured, current.angularMeasured}
+	if fault := sb.safety.checkStale(staleVelocity, sensorRoleName(sb.velocities), velocities, now, period); fault != nil {
+		return true, sb.safetyStop(ctx, fault)
+	}
+	if sb.geofence != nil {
+		if fault := sb.safety.checkGeofence(sb.geofence.marginMm, now); fault != nil {
+			return true, sb.safetyStop(ctx, fault)
+		}
+	}
+	if fault := sb.safety.checkTilt(now); fault != nil {
+		return true, sb.safetyStop(ctx, fault)
+	}
+	return false, nil
+}
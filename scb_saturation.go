@@ -0,0 +1,132 @@
+package controlledcomponents
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"go.viam.com/rdk/control"
+)
+
+// saturation clamps the power sent to each axis of the base to the range of -1 to 1 and counts how often
+// each axis saturates. The control package does not let the integrator of a PID be paused, so the integrators
+// of the linear and angular PIDs are mirrored here, and whatever they add while their output is saturated
+// is held back from the output instead. This has the same effect as the integrator not winding up at all.
+// The lateral loop is only clamped and counted.
+type saturation struct {
+	mu sync.Mutex
+	// saturated and events are indexed like the axes of the base, linear, angular then lateral
+	saturated [3]bool
+	events    [3]int
+	// integral mirrors the integrator of the linear and angular PIDs in units of power,
+	// and held is how far they grew while saturated, which is taken back off their output
+	integral [2]float64
+	held     [2]float64
+	// ki is the integral gain each PID is running with, noted when the loop is set up or switches gain bands
+	ki [2]float64
+}
+
+// resetIntegrators forgets the integrators of the linear and angular PIDs, which the control loop
+// resets when it resumes from a pause, switches gains, or is rebuilt.
+func (s *saturation) resetIntegrators() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.integral = [2]float64{}
+	s.held = [2]float64{}
+}
+
+// takeHeld returns the output held back from the PID of an axis and forgets its integrator,
+// for when its gains are about to be switched.
+func (s *saturation) takeHeld(axis int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := s.held[axis]
+	s.integral[axis] = 0
+	s.held[axis] = 0
+	return held
+}
+
+// setIntegralGain notes the integral gain the PID of an axis is now running with.
+func (s *saturation) setIntegralGain(axis int, ki float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ki[axis] = ki
+}
+
+// holdWindup advances the mirror of the integrator of an axis by one tick of dt seconds with
+// an error of pidErr, and returns the PID output with any windup held back. The integrator is
+// held whenever the output it drives, including the feedforward ff, would be saturated in the direction it grew.
+func (s *saturation) holdWindup(axis int, output, ff, pidErr, dt float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the control package limits the integrator to the same range as the output
+	integral := math.Max(-1, math.Min(1, s.integral[axis]+s.ki[axis]*pidErr*dt*rPiGain))
+	growth := integral - s.integral[axis]
+	s.integral[axis] = integral
+	if total := output + s.held[axis] + ff; math.Abs(total) > 1 && growth*total > 0 {
+		s.held[axis] -= growth
+	}
+	return output + s.held[axis]
+}
+
+// limit clamps the power of an axis to the range of -1 to 1, returning the clamped power and the number
+// of times the axis has saturated if it has just become saturated, or 0 otherwise.
+func (s *saturation) limit(axis int, power float64) (float64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saturated := math.Abs(power) > 1
+	newEvent := saturated && !s.saturated[axis]
+	s.saturated[axis] = saturated
+	if !saturated {
+		return power, 0
+	}
+	power = math.Copysign(1, power)
+	if !newEvent {
+		return power, 0
+	}
+	s.events[axis]++
+	return power, s.events[axis]
+}
+
+// counts returns the number of times each axis has saturated.
+func (s *saturation) counts() [3]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+// limitPowers clamps the linear, angular and lateral powers sent to the base and logs each axis that becomes saturated.
+func (sb *sensorBase) limitPowers(ctx context.Context, powers [3]float64) [3]float64 {
+	for axis, power := range powers {
+		clamped, events := sb.saturation.limit(axis, power)
+		if events != 0 {
+			sb.logger.CWarnf(ctx, "%s output of %.3f saturated, clamped to %v (saturated %d times)",
+				axisName(axis), power, clamped, events)
+		}
+		powers[axis] = clamped
+	}
+	return powers
+}
+
+// noteIntegralGains notes the integral gains of the PIDs in the control config the loop is built from,
+// so the control loop does not have to look them up on every tick.
+func (sb *sensorBase) noteIntegralGains() {
+	if sb.controlLoopConfig == nil {
+		return
+	}
+	for axis, name := range sb.blockNames[pidBlockType] {
+		if axis > 1 {
+			break
+		}
+		ki := 0.
+		for _, blk := range sb.controlLoopConfig.Blocks {
+			if blk.Name != name {
+				continue
+			}
+			if sets, ok := blk.Attribute["PIDSets"].([]*control.PIDConfig); ok && len(sets) != 0 {
+				ki = sets[0].I
+			}
+		}
+		sb.saturation.setIntegralGain(axis, ki)
+	}
+}
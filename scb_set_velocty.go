@@ -9,13 +9,23 @@ import (
 // SetVelocity commands a base to move at the requested linear and angular velocites.
 // When controls are enabled, SetVelocity polls the provided velocity movement sensor and corrects
 // any error between the desired velocity and the actual velocity using a PID control loop.
+// With heading hold enabled, a command with no angular velocity also holds the heading the base had
+// when the command arrived, until the next command replaces it.
+// With a velocity_command_timeout configured, the base is ramped down to a stop if no new command arrives in time.
 func (sb *sensorBase) SetVelocity(
 	ctx context.Context, linear, angular r3.Vector, extra map[string]interface{},
 ) error {
 	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
 	ctx, done := sb.opMgr.New(ctx)
 	defer done()
 
+	// a tilted base refuses every command until it is level again
+	if err := sb.checkLevel(ctx); err != nil {
+		return err
+	}
+
 	if sb.controlLoopConfig == nil {
 		sb.logger.CWarnf(ctx, "control parameters not configured, using %v's SetVelocity method", sb.controlledBase.Name().ShortName())
 		return sb.controlledBase.SetVelocity(ctx, linear, angular, extra)
@@ -26,6 +36,15 @@ func (sb *sensorBase) SetVelocity(
 		return err
 	}
 
+	// a safety check that stopped the base after the last command returned is reported instead of moving again
+	if err := sb.safety.takeUnreported(); err != nil {
+		return err
+	}
+
+	if err := sb.checkGeofenceVelocity(ctx, linear); err != nil {
+		return err
+	}
+
 	// make sure the control loop is enabled
 	if sb.loop == nil {
 		if err := sb.startControlLoop(); err != nil {
@@ -33,11 +52,37 @@ func (sb *sensorBase) SetVelocity(
 		}
 	}
 
-	// convert linear.Y mmPerSec to mPerSec, angular.Z is degPerSec
-	if err := sb.updateControlConfig(ctx, linear.Y/1000.0, angular.Z); err != nil {
+	// consecutive SetVelocity calls, such as from a joystick, are written to the same run until another command ends it
+	if sb.history.runKind() != runSetVelocity {
+		sb.startRun(ctx, runSetVelocity, map[string]interface{}{
+			"linear_mm_per_sec":   linear.Y,
+			"lateral_mm_per_sec":  linear.X,
+			"angular_deg_per_sec": angular.Z,
+		})
+	}
+
+	if linear.X != 0 && sb.lateral == nil {
+		sb.logger.CWarn(ctx, "lateral velocity requested but no lateral_velocity control_parameters configured, ignoring linear.X")
+	}
+
+	// convert linear mmPerSec to mPerSec, angular.Z is degPerSec
+	if err := sb.updateVelocitySetpoints(ctx, linear.X/1000.0, linear.Y/1000.0, angular.Z); err != nil {
 		return err
 	}
-	sb.loop.Resume()
+	sb.resumeControlLoop()
+	sb.safety.noteCommand()
+	// a SetVelocity of zero already holds the base still, so there is nothing for the watchdog to stop
+	if linear.X != 0 || linear.Y != 0 || angular.Z != 0 {
+		sb.startWatchdog(linear.X / 1000.0)
+	}
 
+	// hold the current heading while driving without turning, so that the base does not drift off course
+	if angular.Z == 0 && (linear.X != 0 || linear.Y != 0) && sb.headingHoldEnabled(extra) {
+		tunables, err := sb.motionTunables(extra)
+		if err != nil {
+			return err
+		}
+		return sb.startHeadingHold(ctx, tunables.headingGain)
+	}
 	return nil
 }
@@ -8,8 +8,12 @@ import (
 )
 
 const (
-	increment        = 0.01 // angle fraction multiplier to check
-	oneTurn          = 360.0
+	increment = 0.01 // angle fraction multiplier to check
+	oneTurn   = 360.0
+)
+
+// defaults of the Spin tunables.
+const (
 	maxSlowDownAng   = 30. // maximum angle from goal for spin to begin breaking
 	slowDownAngGain  = 0.1 // Use the final 10% of the requested spin to slow down
 	boundCheckTarget = 1.  // error threshold for spin
@@ -21,9 +25,15 @@ const (
 // Spin also monitors the angleDeg and stops the base when the goal angle is reached.
 func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, extra map[string]interface{}) error {
 	sb.opMgr.CancelRunning(ctx)
-	ctx, done := sb.opMgr.New(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	ctx, done := sb.newOperation(ctx)
 	defer done()
 
+	if err := sb.checkLevel(ctx); err != nil {
+		return err
+	}
+
 	// If an orientation movement sensor or controls are not configured, we cannot use this Spin method.
 	// Instead we need to use the Spin method of the base that the sensorBase wraps.
 	// If there is no valid velocity sensor, there won't be a controlLoopConfig.
@@ -37,6 +47,11 @@ func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, ex
 		return err
 	}
 
+	tunables, err := sb.motionTunables(extra)
+	if err != nil {
+		return err
+	}
+
 	prevAngle, hasOrientation, err := sb.headingFunc(ctx)
 	if err != nil {
 		return err
@@ -54,39 +69,43 @@ func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, ex
 		}
 	}
 
-	// pause and resume the loop to reset the control blocks
-	// This prevents any residual signals in the control loop from "kicking" the robot
-	sb.loop.Pause()
-	sb.loop.Resume()
+	sb.resetControlLoop()
 	var angErr, angMoved float64
 
+	run := sb.startRun(ctx, runSpin, map[string]interface{}{"angle_deg": angleDeg, "degs_per_sec": degsPerSec})
+	defer sb.endRun(run)
+
 	// to keep the signs simple, ensure degsPerSec is positive and let angleDeg handle the direction of the spin
 	if degsPerSec < 0 {
 		angleDeg = -angleDeg
 		degsPerSec = -degsPerSec
 	}
-	slowDownAng := calcSlowDownAng(angleDeg)
+	slowDownAng := calcSlowDownAng(angleDeg, tunables.spinSlowDownGain, tunables.maxSpinSlowDownDeg)
+	profile := sb.angularProfile()
+	tickSecs := 1. / sb.controlLoopConfig.Frequency
 
 	ticker := time.NewTicker(time.Duration(1000./sb.controlLoopConfig.Frequency) * time.Millisecond)
 	defer ticker.Stop()
 
 	// timeout duration is a multiplier times the expected time to perform a movement
-	spinTimeEst := time.Duration(int(time.Second) * int(math.Abs(angleDeg/degsPerSec)))
+	spinTimeEst := time.Duration(int(time.Second)*int(math.Abs(angleDeg/degsPerSec))) + profile.rampTime(degsPerSec)
 	startTime := time.Now()
-	timeOut := 5 * spinTimeEst
-	if timeOut < 10*time.Second {
-		timeOut = 10 * time.Second
-	}
+	timeOut := tunables.timeout(spinTimeEst)
 	prevTime := startTime
 
 	for {
-		if err := ctx.Err(); err != nil {
+		if ctx.Err() != nil {
 			ticker.Stop()
-			return err
+			return context.Cause(ctx)
 		}
 
 		select {
 		case <-ctx.Done():
+			// a safety check that stopped the base cancels with the fault as the cause
+			var fault *SafetyStopError
+			if errors.As(context.Cause(ctx), &fault) {
+				return fault
+			}
 			// context.cancelled can happen due to UI being closed during Spin.
 			// Do not return context canceled errors, just log them
 			if errors.Is(ctx.Err(), context.Canceled) {
@@ -101,6 +120,7 @@ func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, ex
 				if err != nil {
 					return err
 				}
+				sb.history.noteHeading(currYaw)
 				// use initial angle to get the current angle the spin has moved
 				angMoved = getMovedAng(prevAngle, currYaw, angMoved)
 
@@ -124,10 +144,15 @@ func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, ex
 			// compute the error
 			angErr = (angleDeg - angMoved)
 
-			if math.Abs(angErr) < boundCheckTarget {
+			if math.Abs(angErr) < tunables.spinToleranceDeg {
 				return sb.Stop(ctx, nil)
 			}
-			angVel := calcAngVel(angErr, degsPerSec, slowDownAng)
+			var angVel float64
+			if profile != nil {
+				angVel = profile.next(degsPerSec, angErr, tickSecs)
+			} else {
+				angVel = calcAngVel(angErr, degsPerSec, slowDownAng)
+			}
 
 			if err := sb.updateControlConfig(ctx, 0, angVel); err != nil {
 				return err
@@ -145,8 +170,8 @@ func (sb *sensorBase) Spin(ctx context.Context, angleDeg, degsPerSec float64, ex
 // calcSlowDownAng computes the angle at which the spin should begin to slow down.
 // This helps to prevent overshoot when reaching the goal and reduces the jerk on the robot when the spin is complete.
 // This term should always be positive.
-func calcSlowDownAng(angleDeg float64) float64 {
-	return math.Min(math.Abs(angleDeg)*slowDownAngGain, maxSlowDownAng)
+func calcSlowDownAng(angleDeg, gain, maxAng float64) float64 {
+	return math.Min(math.Abs(angleDeg)*gain, maxAng)
 }
 
 // calcAngVel computes the desired angular velocity based on how far the base is from reaching the goal.
@@ -0,0 +1,114 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"go.viam.com/rdk/components/movementsensor"
+)
+
+const (
+	safetyStale = "stale_sensor"
+	// the sensor roles whose readings are checked for staleness
+	staleVelocity = "velocity"
+	staleHeading  = "heading"
+	stalePosition = "position"
+	// stalePowerThreshold is the power an axis has to be driven with for unchanging readings to count as stale,
+	// as the readings of a base at rest are expected not to change
+	stalePowerThreshold = 0.05
+	// stalePoweredTicks is how many ticks of the control loop the base still counts as driven after its last power
+	stalePoweredTicks = 2
+)
+
+// ErrStaleSensor matches, with errors.Is, the error a command returns when a stale sensor reading stopped the base.
+var ErrStaleSensor = &SafetyStopError{Check: safetyStale}
+
+// staleCheck tracks how long the readings of one sensor role have gone without changing.
+type staleCheck struct {
+	ticks  int
+	maxAge time.Duration
+	// last is the latest reading, repeats is how many ticks it has been read without changing since changedAt,
+	// and countedAt is when the last repeat was counted, so that reads within the same tick count once
+	last      []float64
+	repeats   int
+	changedAt time.Time
+	countedAt time.Time
+}
+
+// newStaleChecks returns the staleness checks of the configured sensor roles.
+func newStaleChecks(confs []StaleSensorConfig) map[string]*staleCheck {
+	checks := map[string]*staleCheck{}
+	for _, conf := range confs {
+		checks[conf.Type] = &staleCheck{ticks: conf.Ticks, maxAge: time.Duration(conf.MaxAgeSec * float64(time.Second))}
+	}
+	return checks
+}
+
+// notePower records the powers sent to the base at now, so that the staleness checks know whether it is being driven.
+func (s *safetyMonitor) notePower(powers [3]float64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, power := range powers {
+		if math.Abs(power) >= stalePowerThreshold {
+			s.poweredAt = now
+			return
+		}
+	}
+}
+
+// checkStale advances the staleness check of a sensor role with a reading from the named sensor at now,
+// with period being the period of the control loop. It returns a fault once the reading has not changed for
+// the ticks or max age of the role while the base was driven.
+func (s *safetyMonitor) checkStale(role, name string, reading []float64, now time.Time, period time.Duration) *SafetyStopError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check := s.stale[role]
+	if check == nil {
+		return nil
+	}
+	powered := !s.poweredAt.IsZero() && now.Sub(s.poweredAt) <= stalePoweredTicks*period
+	if !powered || !slices.Equal(reading, check.last) {
+		check.last = reading
+		check.repeats = 0
+		check.changedAt, check.countedAt = now, now
+		return nil
+	}
+	if now.Sub(check.countedAt) >= period/2 {
+		check.repeats++
+		check.countedAt = now
+	}
+
+	var detail string
+	switch age := now.Sub(check.changedAt); {
+	case check.ticks > 0 && check.repeats >= check.ticks:
+		detail = fmt.Sprintf("the %s reading of %s has not changed for %d ticks while the base was driven", role, name, check.repeats)
+	case check.maxAge > 0 && age >= check.maxAge:
+		detail = fmt.Sprintf("the %s reading of %s has not changed for %v while the base was driven",
+			role, name, age.Round(time.Millisecond))
+	default:
+		return nil
+	}
+	check.last = nil
+	return &SafetyStopError{Check: safetyStale, Detail: detail, Time: now}
+}
+
+// checkStaleReading checks a heading or position reading of an operation for staleness, and stops the base
+// and returns the fault if it is stale. The caller must not hold sb.mu.
+func (sb *sensorBase) checkStaleReading(
+	ctx context.Context, role string, ms movementsensor.MovementSensor, reading ...float64,
+) error {
+	period := time.Duration(float64(time.Second) / sb.controlFreq)
+	fault := sb.safety.checkStale(role, sensorRoleName(ms), reading, time.Now(), period)
+	if fault == nil {
+		return nil
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if err := sb.safetyStop(ctx, fault); err != nil {
+		sb.logger.CError(ctx, err)
+	}
+	return fault
+}
@@ -2,7 +2,11 @@ package controlledcomponents
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
@@ -12,6 +16,7 @@ import (
 	geo "github.com/kellydunn/golang-geo"
 	"go.viam.com/rdk/components/base"
 	"go.viam.com/rdk/components/movementsensor"
+	"go.viam.com/rdk/components/sensor"
 	"go.viam.com/rdk/control"
 	"go.viam.com/rdk/logging"
 	"go.viam.com/rdk/resource"
@@ -21,6 +26,7 @@ import (
 	rdkutils "go.viam.com/rdk/utils"
 	"go.viam.com/test"
 	"go.viam.com/utils"
+	utilstestutils "go.viam.com/utils/testutils"
 )
 
 const (
@@ -108,6 +114,66 @@ func addBaseDependency(deps resource.Dependencies) resource.Dependencies {
 	return deps
 }
 
+// lockedMovementSensor serializes the readings of an injected movement sensor, as a real driver guards its state.
+// The injected sensor records the extra of each reading without a lock, so the control loop and a running command
+// reading it at the same time would race.
+type lockedMovementSensor struct {
+	*inject.MovementSensor
+	mu sync.Mutex
+}
+
+func (ls *lockedMovementSensor) Position(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.Position(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) LinearVelocity(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.LinearVelocity(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) AngularVelocity(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.AngularVelocity(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) Orientation(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.Orientation(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) CompassHeading(ctx context.Context, extra map[string]interface{}) (float64, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.CompassHeading(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) Readings(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.Readings(ctx, extra)
+}
+
+func (ls *lockedMovementSensor) Properties(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.MovementSensor.Properties(ctx, extra)
+}
+
+// lockSensors wraps every injected movement sensor of deps in a lockedMovementSensor. It is called once the
+// readings of the sensors are set up, for tests where a command reads a sensor while the control loop does.
+func lockSensors(deps resource.Dependencies) {
+	for name, dep := range deps {
+		if ms, ok := dep.(*inject.MovementSensor); ok {
+			deps[name] = &lockedMovementSensor{MovementSensor: ms}
+		}
+	}
+}
+
 func TestSensorBase(t *testing.T) {
 	ctx := context.Background()
 	logger := logging.NewTestLogger(t)
@@ -204,6 +270,9 @@ func msDependencies(t *testing.T, msNames []string,
 			ms.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
 				return &geo.Point{}, 0, nil
 			}
+			ms.ReadingsFunc = func(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+				return map[string]interface{}{}, nil
+			}
 			deps[movementsensor.Named(msName)] = ms
 		case strings.Contains(msName, "compass"):
 			ms.PropertiesFunc = func(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
@@ -502,16 +571,16 @@ func TestSensorBaseMoveStraight(t *testing.T) {
 		test.That(t, headingOri, test.ShouldEqual, 179)
 
 		// test -179 -> 179 results in a small error
-		headingErr, err := sb.calcHeadingControl(ctx, -179)
+		headingErr, err := sb.calcHeadingControl(ctx, -179, headingGain)
 		test.That(t, err, test.ShouldBeNil)
 		test.That(t, headingErr, test.ShouldEqual, 2)
 
 		// test full circle results in 0 error
-		headingErr2, err := sb.calcHeadingControl(ctx, 360+179)
+		headingErr2, err := sb.calcHeadingControl(ctx, 360+179, headingGain)
 		test.That(t, err, test.ShouldBeNil)
 		test.That(t, headingErr2, test.ShouldEqual, 0)
 		for i := -720; i <= 720; i += 30 {
-			headingErr, err := sb.calcHeadingControl(ctx, float64(i))
+			headingErr, err := sb.calcHeadingControl(ctx, float64(i), headingGain)
 			test.That(t, err, test.ShouldBeNil)
 			test.That(t, headingErr, test.ShouldBeBetweenOrEqual, -180, 180)
 		}
@@ -541,3 +610,1886 @@ func TestSensorBaseDoCommand(t *testing.T) {
 	test.That(t, err, test.ShouldBeNil)
 	test.That(t, resp, test.ShouldResemble, expectedeMap)
 }
+
+func TestSensorFusion(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	deps := make(resource.Dependencies)
+	var mu sync.Mutex
+	yaws := map[string]float64{"fused1": 170, "fused2": -170}
+	for name, vals := range map[string]struct{ linY float64 }{
+		"fused1": {linY: 1},
+		"fused2": {linY: 3},
+	} {
+		ms := inject.NewMovementSensor(name)
+		ms.PropertiesFunc = func(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
+			return &movementsensor.Properties{
+				OrientationSupported:     true,
+				AngularVelocitySupported: true,
+				LinearVelocitySupported:  true,
+			}, nil
+		}
+		ms.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+			return r3.Vector{Y: vals.linY}, nil
+		}
+		ms.AngularVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+			return spatialmath.AngularVelocity{Z: vals.linY}, nil
+		}
+		ms.OrientationFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return &spatialmath.EulerAngles{Yaw: rdkutils.DegToRad(yaws[name])}, nil
+		}
+		deps[movementsensor.Named(name)] = ms
+	}
+	// a GPS and wheeled odometry report positions in different frames
+	for name, latDeg := range map[string]float64{"gps": 40, "odom": 2} {
+		ms := inject.NewMovementSensor(name)
+		ms.PropertiesFunc = func(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
+			return &movementsensor.Properties{PositionSupported: true}, nil
+		}
+		ms.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+			return geo.NewPoint(latDeg, 0), 0, nil
+		}
+		ms.ReadingsFunc = func(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+			if name == "odom" {
+				return map[string]interface{}{localPositionReading: latDeg}, nil
+			}
+			return map[string]interface{}{}, nil
+		}
+		deps[movementsensor.Named(name)] = ms
+	}
+	deps = addBaseDependency(deps)
+
+	cfg := sBaseTestConfig([]string{"fused1", "fused2", "gps", "odom"}, defaultControlFreq, typeLinVel, typeAngVel)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	// without fusion the first capable sensor is used
+	state, err := sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state, test.ShouldResemble, []float64{1, 1})
+	heading, _, err := sb.headingFunc(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, heading, test.ShouldAlmostEqual, 170)
+
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.FuseSensors = true
+	conf.SensorWeights = map[string]float64{"fused2": 3}
+	test.That(t, b.Reconfigure(ctx, deps, cfg), test.ShouldBeNil)
+	test.That(t, sb.velocities.Name().ShortName(), test.ShouldResemble, "fused1")
+
+	state, err = sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldAlmostEqual, 2.5)
+	test.That(t, state[1], test.ShouldAlmostEqual, 2.5)
+
+	// orientations are fused in the frame of the first sensor, so a second sensor with another zero does not move the heading
+	heading, _, err = sb.headingFunc(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, heading, test.ShouldAlmostEqual, 170)
+
+	// once both sensors turn across +/-180 the weighted circular mean is near 180, not 0
+	mu.Lock()
+	yaws["fused1"], yaws["fused2"] = -178, -162
+	mu.Unlock()
+	heading, _, err = sb.headingFunc(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, math.Abs(heading), test.ShouldBeGreaterThan, 170)
+
+	// only the position sensors in the frame of the first one are fused
+	pos, _, err := sb.position.Position(ctx, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, pos.Lat(), test.ShouldAlmostEqual, 40)
+	test.That(t, sb.positionFrame, test.ShouldEqual, frameGeodetic)
+
+	conf.SensorPositionFrames = map[string]string{"gps": frameGeodetic, "odom": frameLocal}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.SensorPositionFrames = map[string]string{"gps": "ecef"}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.SensorPositionFrames = nil
+
+	conf.SensorWeights = map[string]float64{"missing": 1}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.SensorWeights = map[string]float64{"fused1": 0}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestSensorFailover(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	var primaryErr error
+	deps := make(resource.Dependencies)
+	for name, linY := range map[string]float64{"primary": 1, "backup": 2} {
+		ms := inject.NewMovementSensor(name)
+		ms.PropertiesFunc = func(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
+			return &movementsensor.Properties{
+				AngularVelocitySupported: true,
+				LinearVelocitySupported:  true,
+			}, nil
+		}
+		ms.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+			if name == "primary" && primaryErr != nil {
+				return r3.Vector{}, primaryErr
+			}
+			return r3.Vector{Y: linY}, nil
+		}
+		ms.AngularVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+			return spatialmath.AngularVelocity{}, nil
+		}
+		deps[movementsensor.Named(name)] = ms
+	}
+	deps = addBaseDependency(deps)
+
+	cfg := sBaseTestConfig([]string{"primary", "backup"}, defaultControlFreq, typeLinVel, typeAngVel)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	failover, ok := sb.velocities.(*failoverSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	state, err := sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldEqual, 1)
+	test.That(t, failover.activeName(), test.ShouldResemble, "primary")
+
+	primaryErr = errors.New("imu disconnected")
+	state, err = sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldEqual, 2)
+	test.That(t, failover.activeName(), test.ShouldResemble, "backup")
+
+	// the failing sensor is ranked last until the retry interval passes
+	test.That(t, failover.readOrder(), test.ShouldResemble, []int{1, 0})
+	primaryErr = nil
+	failover.failedAt[0] = time.Now().Add(-sensorRetryInterval)
+	state, err = sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldEqual, 1)
+	test.That(t, failover.activeName(), test.ShouldResemble, "primary")
+
+	// a sensor that takes over continues from the last reading, rather than jumping to its own zero
+	var readMu sync.Mutex
+	readings := map[string][3]float64{"imu": {10, 350, 1}, "odom": {100, 20, 5}}
+	failing := map[string]bool{}
+	var headingSensors []movementsensor.MovementSensor
+	for _, name := range []string{"imu", "odom"} {
+		ms := inject.NewMovementSensor(name)
+		read := func() ([3]float64, error) {
+			readMu.Lock()
+			defer readMu.Unlock()
+			if failing[name] {
+				return [3]float64{}, errors.New("disconnected")
+			}
+			return readings[name], nil
+		}
+		ms.OrientationFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+			r, err := read()
+			return &spatialmath.EulerAngles{Yaw: rdkutils.DegToRad(r[0])}, err
+		}
+		ms.CompassHeadingFunc = func(ctx context.Context, extra map[string]interface{}) (float64, error) {
+			r, err := read()
+			return r[1], err
+		}
+		ms.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+			r, err := read()
+			return geo.NewPoint(r[2], r[2]), 0, err
+		}
+		headingSensors = append(headingSensors, ms)
+	}
+	setReading := func(name string, r [3]float64, fail bool) {
+		readMu.Lock()
+		defer readMu.Unlock()
+		readings[name] = r
+		failing[name] = fail
+	}
+	aligned := newFailoverSensor("orientation", headingSensors, logger)
+	aligned.alignLocalPositions()
+	readAll := func() (float64, float64, *geo.Point) {
+		orient, err := aligned.Orientation(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		heading, err := aligned.CompassHeading(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		pos, _, err := aligned.Position(ctx, nil)
+		test.That(t, err, test.ShouldBeNil)
+		return rdkutils.RadToDeg(orient.EulerAngles().Yaw), heading, pos
+	}
+	yaw, heading, pos := readAll()
+	test.That(t, yaw, test.ShouldAlmostEqual, 10)
+	test.That(t, heading, test.ShouldAlmostEqual, 350)
+	setReading("imu", readings["imu"], true)
+	yaw, heading, pos = readAll()
+	test.That(t, aligned.activeName(), test.ShouldResemble, "odom")
+	test.That(t, yaw, test.ShouldAlmostEqual, 10)
+	test.That(t, heading, test.ShouldAlmostEqual, 350)
+	test.That(t, pos.Lat(), test.ShouldAlmostEqual, 1)
+	// the backup turns and moves, and its readings follow from where the first sensor left off
+	setReading("odom", [3]float64{110, 30, 6}, false)
+	yaw, heading, pos = readAll()
+	test.That(t, yaw, test.ShouldAlmostEqual, 20)
+	test.That(t, heading, test.ShouldAlmostEqual, 0)
+	test.That(t, pos.Lng(), test.ShouldAlmostEqual, 2)
+	// switching back aligns the recovered sensor in the same way
+	setReading("imu", [3]float64{-175, 40, 9}, false)
+	aligned.failedAt[0] = time.Now().Add(-sensorRetryInterval)
+	yaw, heading, pos = readAll()
+	test.That(t, aligned.activeName(), test.ShouldResemble, "imu")
+	test.That(t, yaw, test.ShouldAlmostEqual, 20)
+	test.That(t, heading, test.ShouldAlmostEqual, 0)
+	test.That(t, pos.Lat(), test.ShouldAlmostEqual, 2)
+
+	// fused sensors leave out failing sensors instead of erroring
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.FuseSensors = true
+	test.That(t, b.Reconfigure(ctx, deps, cfg), test.ShouldBeNil)
+	state, err = sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldAlmostEqual, 1.5)
+	primaryErr = errors.New("imu disconnected")
+	state, err = sb.State(ctx)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, state[0], test.ShouldAlmostEqual, 2)
+
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestTunedGainsFile(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	path := filepath.Join(t.TempDir(), "gains", "tuned_gains.json")
+
+	linear := control.PIDConfig{Type: typeLinVel, P: 1, I: 2}
+	angular := control.PIDConfig{Type: typeAngVel, P: 3, I: 4}
+	test.That(t, saveTunedGains(path, "test", []control.PIDConfig{linear, {Type: typeAngVel}}), test.ShouldBeNil)
+	test.That(t, saveTunedGains(path, "other", []control.PIDConfig{angular}), test.ShouldBeNil)
+	test.That(t, saveTunedGains(path, "test", []control.PIDConfig{angular}), test.ShouldBeNil)
+
+	saved, err := loadTunedGains(path, "test")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, saved, test.ShouldResemble, []control.PIDConfig{linear, angular})
+	saved, err = loadTunedGains(path, "missing")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, saved, test.ShouldBeNil)
+
+	// a config that asks for tuning uses the saved gains instead
+	deps, _ := msDependencies(t, []string{"setvel1"})
+	cfg := sBaseTestConfig([]string{"setvel1"}, defaultControlFreq, typeLinVel, typeAngVel)
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.ControlParameters = []control.PIDConfig{{Type: typeLinVel}, {Type: typeAngVel}}
+	conf.TunedGainsFile = path
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, sb.configPIDVals, test.ShouldResemble, []control.PIDConfig{linear, angular})
+	test.That(t, sb.checkTuningStatus(), test.ShouldBeNil)
+
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getPID: true})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["control_parameters"], test.ShouldResemble, []control.PIDConfig{linear, angular})
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestSensorBaseTuningCommands(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	tr, err := parseTuningRequest(map[string]interface{}{"axis": typeAngVel, "step_pct": 0.2, "max_duration_sec": 5.})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, tr, test.ShouldResemble, tuningRequest{axis: 1, stepPct: 0.2, maxDuration: 5 * time.Second})
+	_, err = parseTuningRequest(map[string]interface{}{"axis": "lateral"})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseTuningRequest(map[string]interface{}{"axis": typeLinVel, "step_pct": 2.})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// only the requested axis is zeroed for tuning
+	tuneConf := sb.tuningConfig(tr)
+	for _, blk := range tuneConf.Blocks {
+		switch blk.Name {
+		case "angular_PID":
+			test.That(t, blk.Attribute["PIDSets"].([]*control.PIDConfig)[0].NeedsAutoTuning(), test.ShouldBeTrue)
+			test.That(t, blk.Attribute["tune_step_pct"], test.ShouldEqual, 0.2)
+		case "linear_PID":
+			test.That(t, blk.Attribute["PIDSets"].([]*control.PIDConfig)[0].NeedsAutoTuning(), test.ShouldBeFalse)
+		}
+	}
+
+	test.That(t, b.SetVelocity(ctx, r3.Vector{}, r3.Vector{}, nil), test.ShouldBeNil)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{startTuning: map[string]interface{}{"axis": typeLinVel}})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp[startTuning], test.ShouldResemble, "tuning linear_velocity")
+	_, err = b.DoCommand(ctx, map[string]interface{}{startTuning: map[string]interface{}{"axis": typeLinVel}})
+	test.That(t, err, test.ShouldBeError, errTuningRunning)
+
+	resp, err = b.DoCommand(ctx, map[string]interface{}{cancelTuning: true})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp[cancelTuning], test.ShouldResemble, "tuning stopped")
+	// the configured gains and the control loop are kept when tuning is cancelled
+	test.That(t, sb.configPIDVals[0].NeedsAutoTuning(), test.ShouldBeFalse)
+	test.That(t, sb.tuneCancel, test.ShouldBeNil)
+	test.That(t, sb.loop, test.ShouldNotBeNil)
+	test.That(t, sb.loop.Running(), test.ShouldBeFalse)
+
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestSensorBaseLateralVelocity(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+
+	// the lateral axis is not tuned on reconfigure, so it cannot be configured without gains
+	conf.ControlParameters = append(conf.ControlParameters, control.PIDConfig{Type: typeLatVel})
+	_, err := conf.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "lateral_velocity gains cannot all be 0")
+
+	// a lateral velocity is driven into the X power of the base
+	deps, cfg = msDependencies(t, []string{"setvel1"})
+	conf = cfg.ConvertedAttributes.(*SCBConfig)
+	lateral := control.PIDConfig{Type: typeLatVel, P: 0.5, I: 0.5}
+	conf.ControlParameters = append(conf.ControlParameters, lateral)
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	var mu sync.Mutex
+	var lateralPower float64
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		lateralPower = linear.X
+		return nil
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, sb.lateral.loop, test.ShouldNotBeNil)
+
+	test.That(t, b.SetVelocity(ctx, r3.Vector{X: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		mu.Lock()
+		defer mu.Unlock()
+		test.That(tb, lateralPower, test.ShouldBeGreaterThan, 0)
+	})
+
+	// tuned lateral gains are reported with the other tuned gains
+	sb.mu.Lock()
+	sb.lateral.tuned = lateral
+	sb.mu.Unlock()
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getPID: true})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["control_parameters"], test.ShouldResemble, []control.PIDConfig{lateral})
+
+	// stopping the base clears the lateral output
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	sb.mu.Lock()
+	test.That(t, sb.lateral.loop.Running(), test.ShouldBeFalse)
+	sb.mu.Unlock()
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestSensorBaseHeadingHold(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	deps, cfg := msDependencies(t, []string{"setvel1", "orientation1"})
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.HeadingHold = true
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	holding := func() bool {
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		return sb.headingHoldCancel != nil
+	}
+
+	// driving straight latches the heading
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeTrue)
+
+	// a turn releases the heading
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{Z: 10}, nil), test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeFalse)
+
+	// extra overrides the attribute
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, map[string]interface{}{headingHoldKey: false}),
+		test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeFalse)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeTrue)
+
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeFalse)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// without a heading sensor there is nothing to hold
+	deps, cfg = msDependencies(t, []string{"setvel1"})
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok = b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, map[string]interface{}{headingHoldKey: true}),
+		test.ShouldBeNil)
+	test.That(t, holding(), test.ShouldBeFalse)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestMotionProfile(t *testing.T) {
+	test.That(t, newMotionProfile("", 100, 0), test.ShouldBeNil)
+	test.That(t, newMotionProfile(profileNone, 100, 0), test.ShouldBeNil)
+
+	// simulate a 1000 mm move at 200 mm/s, following the setpoint perfectly
+	run := func(mp *motionProfile) (maxAccelSeen, maxJerkSeen, traveled float64) {
+		const dt, goal, cruise = 0.01, 1000., 200.
+		prevVel, prevAccel := 0., 0.
+		for i := 0; i < 5000 && goal-traveled > 0.5; i++ {
+			vel := mp.next(cruise, goal-traveled, dt)
+			test.That(t, math.Abs(vel), test.ShouldBeLessThanOrEqualTo, cruise)
+			accel := (vel - prevVel) / dt
+			maxAccelSeen = math.Max(maxAccelSeen, math.Abs(accel))
+			if i > 0 {
+				maxJerkSeen = math.Max(maxJerkSeen, math.Abs(accel-prevAccel)/dt)
+			}
+			traveled += vel * dt
+			prevVel, prevAccel = vel, accel
+		}
+		return maxAccelSeen, maxJerkSeen, traveled
+	}
+
+	accel, _, traveled := run(newMotionProfile(profileTrapezoidal, 100, 0))
+	test.That(t, accel, test.ShouldBeLessThanOrEqualTo, 100+1e-6)
+	test.That(t, traveled, test.ShouldAlmostEqual, 1000, 1)
+
+	accel, jerk, traveled := run(newMotionProfile(profileSCurve, 100, 500))
+	test.That(t, accel, test.ShouldBeLessThanOrEqualTo, 100+1e-6)
+	// the last step onto the target velocity may cut the acceleration to zero at once
+	test.That(t, jerk, test.ShouldBeLessThanOrEqualTo, 100/0.01+1e-6)
+	test.That(t, traveled, test.ShouldAlmostEqual, 1000, 1)
+
+	// the setpoint starts from rest instead of jumping to the commanded speed
+	mp := newMotionProfile(profileTrapezoidal, 100, 0)
+	test.That(t, mp.next(200, 1000, 0.1), test.ShouldAlmostEqual, 10)
+	mp = newMotionProfile(profileSCurve, 100, 500)
+	test.That(t, mp.next(-200, -1000, 0.1), test.ShouldAlmostEqual, -5)
+
+	cfg := &SCBConfig{MovementSensor: []string{"ms"}, Base: "base", MotionProfile: profileSCurve, MaxLinearAccel: 100, MaxAngularAccel: 90}
+	_, err := cfg.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "must be set for the s_curve motion_profile")
+	cfg.MaxLinearJerk, cfg.MaxAngularJerk = 500, 360
+	_, err = cfg.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	cfg.MotionProfile = "linear"
+	_, err = cfg.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "motion_profile must be")
+}
+
+func TestMotionTunables(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	deps, cfg := msDependencies(t, []string{"setvel1", "orientation1"})
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.SpinToleranceDeg = 2
+	conf.TimeoutMultiplier = 3
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	// unset values keep the defaults, and extra overrides the config
+	mt, err := sb.motionTunables(map[string]interface{}{"timeout_multiplier": 4., "heading_gain": 0.5})
+	test.That(t, err, test.ShouldBeNil)
+	expected := defaultMotionTunables()
+	expected.spinToleranceDeg = 2
+	expected.timeoutMultiplier = 4
+	expected.headingGain = 0.5
+	test.That(t, mt, test.ShouldResemble, expected)
+	test.That(t, mt.timeout(time.Second), test.ShouldEqual, 10*time.Second)
+	test.That(t, mt.timeout(5*time.Second), test.ShouldEqual, 20*time.Second)
+
+	_, err = sb.motionTunables(map[string]interface{}{"spin_slow_down_gain": 2.})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "spin_slow_down_gain must be at most 1")
+	_, err = sb.motionTunables(map[string]interface{}{"min_timeout_sec": "ten"})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "min_timeout_sec must be a number")
+	err = b.Spin(ctx, 10, 10, map[string]interface{}{"spin_tolerance_deg": -1.})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "spin_tolerance_deg must be greater than 0")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	conf.StraightSlowDownGain = 1.5
+	_, err = conf.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "straight_slow_down_gain must be at most 1")
+	conf.StraightSlowDownGain = 0
+	conf.MaxStraightSlowDownMm = -10
+	_, err = conf.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "max_straight_slow_down_mm must be greater than 0")
+}
+
+func TestMoveStraightOvershoot(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	ms, ok := deps[movementsensor.Named("setvel1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	// the base keeps moving forward at 1 m/s whatever it is commanded to do
+	ms.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+		return r3.Vector{Y: 1}, nil
+	}
+	lockSensors(deps)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+
+	_, err = b.DoCommand(ctx, map[string]interface{}{getStraightErr: true})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// without a tolerance the base stops as soon as it passes the goal
+	test.That(t, b.MoveStraight(ctx, 50, 100, nil), test.ShouldBeNil)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getStraightErr: true})
+	test.That(t, err, test.ShouldBeNil)
+	result, ok := resp[getStraightErr].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, result["error_mm"], test.ShouldBeLessThan, 0)
+	test.That(t, result["overshoot"], test.ShouldBeTrue)
+
+	// with a tolerance the base tries to reverse back to the goal, and reports how far past it ended when it times out
+	extra := map[string]interface{}{overshootToleranceKey: 5., "min_timeout_sec": 0.5}
+	test.That(t, b.MoveStraight(ctx, 50, 100, extra), test.ShouldBeNil)
+	resp, err = b.DoCommand(ctx, map[string]interface{}{getStraightErr: true})
+	test.That(t, err, test.ShouldBeNil)
+	result, ok = resp[getStraightErr].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, result["error_mm"], test.ShouldBeLessThan, -5)
+
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	_, err = sb.overshootTolerance(map[string]interface{}{overshootToleranceKey: -1.})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestAlongTrackProgress(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	// a sensor reporting meters in its readings is used in the local frame
+	deps, cfg := msDependencies(t, []string{"setvel1", "position1"})
+	ms, ok := deps[movementsensor.Named("position1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	ms.ReadingsFunc = func(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{localPositionReading: 0.}, nil
+	}
+	var x, y float64
+	ms.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+		if relative, _ := extra[relativePositionExtra].(bool); !relative {
+			return nil, 0, errors.New("expected a relative position request")
+		}
+		return geo.NewPoint(y, x), 0, nil
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, sb.positionFrame, test.ShouldEqual, frameLocal)
+
+	track, err := sb.newAlongTrack(ctx, 500, 1)
+	test.That(t, err, test.ShouldBeNil)
+	// the straight line distance is used until the base has moved far enough to know its course
+	x, y = 0, 0.05
+	errDist, err := sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 450)
+	x, y = 0, 0.2
+	errDist, err = sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 300)
+	// the course is taken over half of the move
+	x, y = 0, 0.3
+	errDist, err = sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 200)
+	// sideways wander is not progress
+	x, y = 0.1, 0.35
+	errDist, err = sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 150)
+	// going past the goal is a negative error
+	x, y = 0, 0.6
+	errDist, err = sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, -100)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// odometry that reports its orientation in the local frame gives the course up front, with yaw 0 along +Y
+	ms.PropertiesFunc = func(ctx context.Context, extra map[string]interface{}) (*movementsensor.Properties, error) {
+		return &movementsensor.Properties{PositionSupported: true, OrientationSupported: true}, nil
+	}
+	ms.OrientationFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+		return &spatialmath.EulerAngles{Yaw: rdkutils.DegToRad(90)}, nil
+	}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok = b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	x, y = 0, 0
+	track, err = sb.newAlongTrack(ctx, 500, 1)
+	test.That(t, err, test.ShouldBeNil)
+	// a base facing 90 degrees CCW drives along -X, and sideways wander along Y is not progress
+	x, y = -0.1, 0.05
+	errDist, err = sb.calcPositionError(ctx, 500, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 400)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// in the geodetic frame the course comes from the compass heading
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1", "compass1"})
+	cfg.ConvertedAttributes.(*SCBConfig).PositionFrame = frameGeodetic
+	ms, ok = deps[movementsensor.Named("position1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	start := geo.NewPoint(40, -74)
+	pos := start
+	ms.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+		return pos, 0, nil
+	}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok = b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, sb.positionFrame, test.ShouldEqual, frameGeodetic)
+
+	track, err = sb.newAlongTrack(ctx, 2000, 1)
+	test.That(t, err, test.ShouldBeNil)
+	// one meter north of the start is cos(45) meters along a north east course
+	pos = start.PointAtDistanceAndBearing(0.001, 0)
+	errDist, err = sb.calcPositionError(ctx, 2000, track)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, errDist, test.ShouldAlmostEqual, 2000-1000*math.Cos(math.Pi/4), 1)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestMoveToPose(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	pr, err := parsePoseRequest(map[string]interface{}{"x_mm": 100., "y_mm": 500., "theta_deg": 90.})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, pr, test.ShouldResemble, poseRequest{
+		xMm: 100, yMm: 500, thetaDeg: 90,
+		mmPerSec: defaultPoseMmPerSec, degsPerSec: defaultPoseDegsPerSec, toleranceMm: defaultPoseTolMm,
+	})
+	_, err = parsePoseRequest(map[string]interface{}{"y_mm": 500., "mm_per_sec": -1.})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// a heading of 0 faces north, and -90 faces east
+	test.That(t, forwardOf(0).Y, test.ShouldAlmostEqual, 1)
+	test.That(t, forwardOf(-90).X, test.ShouldAlmostEqual, 1)
+	test.That(t, rightOf(0).X, test.ShouldAlmostEqual, 1)
+	test.That(t, wrapAngleDeg(270), test.ShouldAlmostEqual, -90)
+
+	// a target straight ahead is driven to without turning
+	linVel, angVel := purePursuit(r3.Vector{Y: 1000}, 0, 200, 45, 100)
+	test.That(t, linVel, test.ShouldAlmostEqual, 200)
+	test.That(t, angVel, test.ShouldAlmostEqual, 0)
+	// a target ahead and to the left turns the base left while driving
+	linVel, angVel = purePursuit(r3.Vector{X: -300, Y: 1000}, 0, 200, 45, 100)
+	test.That(t, linVel, test.ShouldBeGreaterThan, 0)
+	test.That(t, angVel, test.ShouldBeGreaterThan, 0)
+	// a target behind the base turns it in place
+	linVel, angVel = purePursuit(r3.Vector{X: 300, Y: -1000}, 0, 200, 45, 100)
+	test.That(t, linVel, test.ShouldEqual, 0)
+	test.That(t, angVel, test.ShouldBeLessThan, 0)
+
+	// a position sensor is required
+	deps, cfg := msDependencies(t, []string{"setvel1", "orientation1"})
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = b.DoCommand(ctx, map[string]interface{}{moveToPose: map[string]interface{}{"y_mm": 500.}})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "requires a position sensor")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// the yaw of an orientation sensor has its own zero, so it cannot place a target in the frame of a GPS
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1", "orientation1"})
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = b.DoCommand(ctx, map[string]interface{}{moveToPose: map[string]interface{}{"y_mm": 500.}})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "needs a compass in the geodetic frame")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// cancelling a move is not an error, the same as MoveStraight and Spin
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1", "compass1"})
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	moving := make(chan struct{})
+	var once sync.Once
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		once.Do(func() { close(moving) })
+		return nil
+	}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := b.DoCommand(cancelCtx, map[string]interface{}{moveToPose: map[string]interface{}{"y_mm": 5000.}})
+		errCh <- err
+	}()
+	<-moving
+	cancel()
+	test.That(t, <-errCh, test.ShouldBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestFollowWaypoints(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	waypoints, err := parseWaypoints(map[string]interface{}{
+		"mm_per_sec": 300.,
+		"waypoints": []interface{}{
+			map[string]interface{}{"lat": 40., "lng": -74.},
+			map[string]interface{}{"lat": 40.001, "lng": -74., "arrival_radius_m": 2.},
+		},
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, waypoints, test.ShouldHaveLength, 2)
+	test.That(t, waypoints[0].mmPerSec, test.ShouldEqual, 300)
+	test.That(t, waypoints[0].arrivalRadiusM, test.ShouldEqual, defaultArrivalRadiusM)
+	test.That(t, waypoints[1].arrivalRadiusM, test.ShouldEqual, 2)
+	_, err = parseWaypoints(map[string]interface{}{"waypoints": []interface{}{map[string]interface{}{"lat": 40.}}})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// a compass heading sensor is required
+	deps, cfg := msDependencies(t, []string{"setvel1", "position1"})
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	wpReq := map[string]interface{}{"waypoints": []interface{}{map[string]interface{}{"lat": 0., "lng": 0.}}}
+	_, err = b.DoCommand(ctx, map[string]interface{}{followWaypoints: wpReq})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "requires a compass heading sensor")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// an orientation sensor cannot stand in for the compass, its yaw is not relative to north
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1", "orientation1"})
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = b.DoCommand(ctx, map[string]interface{}{followWaypoints: wpReq})
+	test.That(t, err.Error(), test.ShouldContainSubstring, "heading of the base from north")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1", "compass1"})
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = b.DoCommand(ctx, map[string]interface{}{getWaypointProgress: true})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// the base is already at the only waypoint
+	resp, err := b.DoCommand(ctx, map[string]interface{}{followWaypoints: wpReq})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp[followWaypoints], test.ShouldEqual, "following 1 waypoints")
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		resp, err := b.DoCommand(ctx, map[string]interface{}{getWaypointProgress: true})
+		test.That(tb, err, test.ShouldBeNil)
+		test.That(tb, resp[getWaypointProgress].(map[string]interface{})["state"], test.ShouldEqual, waypointsArrived)
+	})
+
+	// stopping the base stops following waypoints
+	farReq := map[string]interface{}{"waypoints": []interface{}{map[string]interface{}{"lat": 0.01, "lng": 0.}}}
+	_, err = b.DoCommand(ctx, map[string]interface{}{followWaypoints: farReq})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		resp, err := b.DoCommand(ctx, map[string]interface{}{getWaypointProgress: true})
+		test.That(tb, err, test.ShouldBeNil)
+		progress := resp[getWaypointProgress].(map[string]interface{})
+		test.That(tb, progress["state"], test.ShouldEqual, waypointsStopped)
+		test.That(tb, progress["total"], test.ShouldEqual, 1)
+	})
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestControlHistory(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	// the oldest samples are replaced once the buffer is full
+	h := newControlHistory(3)
+	for i := 0; i < 5; i++ {
+		h.noteSetpoints(float64(i), 0)
+		h.record(float64(i), 0)
+	}
+	samples := h.between(time.Time{}, time.Time{})
+	test.That(t, samples, test.ShouldHaveLength, 3)
+	for i, sample := range samples {
+		test.That(t, sample.linearSetpoint, test.ShouldEqual, i+2)
+		test.That(t, sample.linearOutput, test.ShouldEqual, i+2)
+	}
+	test.That(t, h.between(time.Now().Add(time.Minute), time.Time{}), test.ShouldBeEmpty)
+
+	_, _, err := parseHistoryWindow(map[string]interface{}{"start_time": "yesterday"})
+	test.That(t, err, test.ShouldNotBeNil)
+	start, _, err := parseHistoryWindow(map[string]interface{}{"last_sec": 5.})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, time.Since(start), test.ShouldBeBetween, 4*time.Second, 6*time.Second)
+
+	// every tick of the control loop is recorded while the base is commanded
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{Z: 10}, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		resp, err := b.DoCommand(ctx, map[string]interface{}{getControlHistory: map[string]interface{}{"last_sec": 10.}})
+		test.That(tb, err, test.ShouldBeNil)
+		samples, ok := resp[getControlHistory].([]interface{})
+		test.That(tb, ok, test.ShouldBeTrue)
+		if len(samples) == 0 {
+			tb.Error("no control history recorded yet")
+			return
+		}
+		latest, ok := samples[len(samples)-1].(map[string]interface{})
+		test.That(tb, ok, test.ShouldBeTrue)
+		test.That(tb, latest["linear_setpoint_m_per_s"], test.ShouldEqual, 0.1)
+		test.That(tb, latest["angular_setpoint_deg_per_s"], test.ShouldEqual, 10)
+	})
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestRunLog(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+	dir := filepath.Join(t.TempDir(), "runs")
+
+	// a SetVelocity session is written to one file until Stop ends it
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.RunLogDir = dir
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 200}, r3.Vector{}, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		test.That(tb, sb.history.between(time.Time{}, time.Time{}), test.ShouldNotBeEmpty)
+	})
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+
+	runs, err := filepath.Glob(filepath.Join(dir, "test_*_set_velocity.csv"))
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, runs, test.ShouldHaveLength, 1)
+	data, err := os.ReadFile(runs[0])
+	test.That(t, err, test.ShouldBeNil)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	test.That(t, len(lines), test.ShouldBeGreaterThan, 3)
+	var metadata map[string]interface{}
+	test.That(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[0], "# ")), &metadata), test.ShouldBeNil)
+	test.That(t, metadata["run"], test.ShouldEqual, runSetVelocity)
+	test.That(t, metadata["control_frequency_hz"], test.ShouldEqual, defaultControlFreq)
+	test.That(t, metadata["movement_sensors"], test.ShouldResemble, []interface{}{"setvel1"})
+	test.That(t, lines[1], test.ShouldEqual, strings.Join(runLogColumns, ","))
+	test.That(t, strings.Split(lines[2], ","), test.ShouldHaveLength, len(runLogColumns))
+	test.That(t, lines[len(lines)-1], test.ShouldContainSubstring, `"truncated":false`)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// JSON runs are a single document, and samples past the size limit are dropped
+	rc := runLogConfig{dir: dir, format: runLogJSON, maxFiles: defaultRunLogMaxFiles, maxBytes: 1 << 20}
+	run, err := createRunLog(rc, "json", runSpin, filepath.Join(dir, "json.json"), map[string]interface{}{"run": runSpin}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	run.write(controlSample{time: time.Now(), linearSetpoint: 0.1})
+	run.write(controlSample{time: time.Now(), linearSetpoint: 0.2})
+	run.maxBytes = 0
+	run.write(controlSample{time: time.Now(), linearSetpoint: 0.3})
+	run.close()
+	data, err = os.ReadFile(run.path)
+	test.That(t, err, test.ShouldBeNil)
+	var doc struct {
+		Metadata  map[string]interface{}   `json:"metadata"`
+		Samples   []map[string]interface{} `json:"samples"`
+		Truncated bool                     `json:"truncated"`
+	}
+	test.That(t, json.Unmarshal(data, &doc), test.ShouldBeNil)
+	test.That(t, doc.Metadata["run"], test.ShouldEqual, runSpin)
+	test.That(t, doc.Samples, test.ShouldHaveLength, 2)
+	test.That(t, doc.Samples[1]["linear_setpoint_m_per_s"], test.ShouldEqual, 0.2)
+	test.That(t, doc.Truncated, test.ShouldBeTrue)
+
+	// samples queued by the control loop are all written before the run is closed
+	rc.format = runLogCSV
+	run, err = createRunLog(rc, "queued", runSpin, filepath.Join(dir, "queued.csv"), map[string]interface{}{"run": runSpin}, logger)
+	test.That(t, err, test.ShouldBeNil)
+	for i := 0; i < 3; i++ {
+		run.enqueue(controlSample{time: time.Now(), linearSetpoint: float64(i)})
+	}
+	run.close()
+	data, err = os.ReadFile(run.path)
+	test.That(t, err, test.ShouldBeNil)
+	// the metadata, the header, three samples and the end of the run
+	test.That(t, strings.Split(strings.TrimSpace(string(data)), "\n"), test.ShouldHaveLength, 6)
+	test.That(t, run.dropped, test.ShouldEqual, 0)
+
+	// only the newest runs of the base are kept, other files in the directory are left alone
+	pruneDir := t.TempDir()
+	files := []string{
+		"base_20260101T000000.000Z_spin.csv",
+		"base_20260102T000000.000Z_move_straight.json",
+		"base_20260103T000000.000Z_set_velocity.csv",
+		"base_x_20260101T000000.000Z_spin.csv",
+		"notes.csv",
+	}
+	for _, name := range files {
+		test.That(t, os.WriteFile(filepath.Join(pruneDir, name), nil, 0o600), test.ShouldBeNil)
+	}
+	test.That(t, pruneRunLogs(pruneDir, "base", 1), test.ShouldBeNil)
+	for i, name := range files {
+		_, err := os.Stat(filepath.Join(pruneDir, name))
+		test.That(t, err == nil, test.ShouldEqual, i >= 2)
+	}
+
+	conf.RunLogFormat = "parquet"
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.RunLogFormat = runLogJSON
+	conf.RunLogMaxFiles = -1
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestCharacterize(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	// a first order response to a step from 0 to 100 that reaches 90% after 1.15s and never overshoots
+	var samples []stepSample
+	for i := 1; i <= 50; i++ {
+		tSec := float64(i) * 0.1
+		samples = append(samples, stepSample{t: tSec, value: 100 * (1 - math.Exp(-tSec/0.5))})
+	}
+	result := stepMetrics(samples, 0, 100, 0.05)
+	test.That(t, result["rise_time_sec"], test.ShouldAlmostEqual, 1.1)
+	test.That(t, result["overshoot_pct"], test.ShouldEqual, 0)
+	test.That(t, result["settled"], test.ShouldBeTrue)
+	test.That(t, result["settling_time_sec"], test.ShouldAlmostEqual, 1.5)
+	test.That(t, result["steady_state_error"], test.ShouldBeBetween, 0, 1)
+
+	// a response to a step down of 50 that goes 20 past the target, an overshoot of 40%, and never settles
+	samples = []stepSample{{0.1, 50}, {0.2, 0}, {0.3, -20}, {0.4, -10}}
+	result = stepMetrics(samples, 50, 0, 0.05)
+	test.That(t, result["overshoot_pct"], test.ShouldAlmostEqual, 40)
+	test.That(t, result["settled"], test.ShouldBeFalse)
+	_, ok := result["settling_time_sec"]
+	test.That(t, ok, test.ShouldBeFalse)
+	test.That(t, result["rms_error"], test.ShouldAlmostEqual, math.Sqrt((50*50+20*20+10*10)/4.))
+
+	cr, err := parseCharacterizeRequest(map[string]interface{}{
+		"axis": typeAngVel, "angular_steps_degs_per_sec": []interface{}{10., 0.}, "step_duration_sec": 0.3,
+	})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, cr.axes, test.ShouldResemble, []string{typeAngVel})
+	test.That(t, cr.angularSteps, test.ShouldResemble, []float64{10, 0})
+	_, err = parseCharacterizeRequest(map[string]interface{}{"axis": "sideways"})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseCharacterizeRequest(map[string]interface{}{"linear_steps_mm_per_sec": []interface{}{"fast"}})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// the base follows the steps and is stopped at the end
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	lockSensors(deps)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{characterize: map[string]interface{}{
+		"linear_steps_mm_per_sec":    []interface{}{100.},
+		"angular_steps_degs_per_sec": []interface{}{10.},
+		"step_duration_sec":          0.3,
+	}})
+	test.That(t, err, test.ShouldBeNil)
+	results, ok := resp[characterize].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	for _, axis := range []string{typeLinVel, typeAngVel} {
+		axisResult, ok := results[axis].(map[string]interface{})
+		test.That(t, ok, test.ShouldBeTrue)
+		test.That(t, axisResult["steps"], test.ShouldHaveLength, 1)
+		test.That(t, axisResult["rms_error"], test.ShouldNotBeNil)
+	}
+	test.That(t, results["stopped"], test.ShouldBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// a Stop part way through returns the axes that finished rather than an error
+	deps, cfg = msDependencies(t, []string{"setvel1"})
+	lockSensors(deps)
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	moving := make(chan struct{})
+	var once sync.Once
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		once.Do(func() { close(moving) })
+		return nil
+	}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	type characterizeResult struct {
+		resp map[string]interface{}
+		err  error
+	}
+	resultCh := make(chan characterizeResult, 1)
+	go func() {
+		resp, err := b.DoCommand(ctx, map[string]interface{}{characterize: map[string]interface{}{
+			"linear_steps_mm_per_sec":    []interface{}{100.},
+			"angular_steps_degs_per_sec": []interface{}{10.},
+			"step_duration_sec":          10.,
+		}})
+		resultCh <- characterizeResult{resp, err}
+	}()
+	<-moving
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	stopped := <-resultCh
+	test.That(t, stopped.err, test.ShouldBeNil)
+	results, ok = stopped.resp[characterize].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, results["stopped"], test.ShouldBeTrue)
+	test.That(t, results[typeLinVel], test.ShouldBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestControlDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	conf := &DiagnosticsConfig{}
+	_, err := conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.Base = "test"
+	deps, err := conf.Validate("path")
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, deps, test.ShouldResemble, []string{"test"})
+
+	msDeps, cfg := msDependencies(t, []string{"setvel1"})
+	b, err := newSCB(ctx, msDeps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	diagCfg := resource.Config{
+		Name:                "diagnostics",
+		API:                 sensor.API,
+		Model:               ControlDiagnosticsModel,
+		ConvertedAttributes: conf,
+	}
+	diag, err := newControlDiagnostics(ctx, resource.Dependencies{base.Named("test"): b}, diagCfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+
+	readings, err := diag.Readings(ctx, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, readings["active_operation"], test.ShouldEqual, operationIdle)
+	test.That(t, readings["tuning_status"], test.ShouldEqual, tuningReady)
+	test.That(t, readings["control_frequency_hz"], test.ShouldEqual, defaultControlFreq)
+	test.That(t, readings["velocity_sensor"], test.ShouldEqual, "setvel1")
+	test.That(t, readings["orientation_sensor"], test.ShouldEqual, "")
+	// the heading is only reported once a motion has sampled it
+	test.That(t, readings["heading_deg"], test.ShouldBeNil)
+	b.(*sensorBase).history.noteHeading(45)
+	readings, err = diag.Readings(ctx, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, readings["heading_deg"], test.ShouldEqual, 45)
+	test.That(t, readings["heading_age_ms"], test.ShouldBeGreaterThanOrEqualTo, 0)
+
+	// the readings follow the base while it is commanded
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{Z: 10}, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		readings, err := diag.Readings(ctx, nil)
+		test.That(tb, err, test.ShouldBeNil)
+		test.That(tb, readings["active_operation"], test.ShouldEqual, runSetVelocity)
+		test.That(tb, readings["linear_setpoint_m_per_s"], test.ShouldEqual, 0.1)
+		test.That(tb, readings["loop_running"], test.ShouldBeTrue)
+		test.That(tb, readings["loop_period_ms"], test.ShouldNotBeNil)
+	})
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	readings, err = diag.Readings(ctx, nil)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, readings["active_operation"], test.ShouldEqual, operationIdle)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// any other base cannot report diagnostics
+	other := inject.NewBase("other")
+	other.DoFunc = func(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{}, nil
+	}
+	conf.Base = "other"
+	diag, err = newControlDiagnostics(ctx, resource.Dependencies{base.Named("other"): other}, diagCfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	_, err = diag.Readings(ctx, nil)
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestFeedforward(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	var ff *feedforward
+	lin, ang := ff.output(time.Now())
+	test.That(t, lin, test.ShouldEqual, 0)
+	test.That(t, ang, test.ShouldEqual, 0)
+
+	ff = newFeedforward([]FeedforwardConfig{
+		{Type: typeLinVel, KV: 2, KA: 0.5, KS: 0.1},
+		{Type: typeAngVel, KV: 0.01, KS: 0.05},
+	})
+	ff.setSetpoint(0, 0.2)
+	ff.setSetpoint(1, -10)
+	start := time.Now()
+	lin, ang = ff.output(start)
+	test.That(t, lin, test.ShouldAlmostEqual, 0.5)
+	test.That(t, ang, test.ShouldAlmostEqual, -0.15)
+	// a change of the setpoint between ticks adds the acceleration term
+	ff.setSetpoint(0, 0.3)
+	lin, _ = ff.output(start.Add(100 * time.Millisecond))
+	test.That(t, lin, test.ShouldAlmostEqual, 1.2)
+	// but not after the loop was paused
+	ff.setSetpoint(0, 0)
+	lin, _ = ff.output(start.Add(5 * time.Second))
+	test.That(t, lin, test.ShouldEqual, 0)
+
+	// the fits recover the gains of a base without inertia
+	plant := func(power, ks, kv float64) float64 { return math.Max(0, (power-ks)/kv) }
+	var ramp, step []ffSample
+	for i := 0; i <= 50; i++ {
+		power := 0.5 * float64(i) / 50
+		ramp = append(ramp, ffSample{power: power, vel: plant(power, 0.1, 0.8)})
+		accel := 1 - float64(i)/50
+		step = append(step, ffSample{power: 0.1 + 0.8*0.2 + 0.3*accel, vel: 0.2, accel: accel})
+	}
+	ks, kv, err := fitStaticAndVelocity(ramp)
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, ks, test.ShouldAlmostEqual, 0.1)
+	test.That(t, kv, test.ShouldAlmostEqual, 0.8)
+	test.That(t, fitAcceleration(step, ks, kv), test.ShouldAlmostEqual, 0.3)
+	_, _, err = fitStaticAndVelocity([]ffSample{{power: 0.5}})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// the ramp test drives the base open loop and reports gains that can be copied into the config
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).ControlFreq = 100
+	var mu sync.Mutex
+	var power r3.Vector
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		power = r3.Vector{X: linear.Y, Y: angular.Z}
+		return nil
+	}
+	velSensor, ok := deps[movementsensor.Named("setvel1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	velSensor.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return r3.Vector{Y: plant(power.X, 0.1, 0.8)}, nil
+	}
+	velSensor.AngularVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return spatialmath.AngularVelocity{Z: plant(power.Y, 0.05, 0.01)}, nil
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{estimateFeedforward: map[string]interface{}{
+		"ramp_sec": 0.5, "step_sec": 0.2,
+	}})
+	test.That(t, err, test.ShouldBeNil)
+	estimates, ok := resp[estimateFeedforward].([]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, estimates, test.ShouldHaveLength, 2)
+	linEst, ok := estimates[0].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, linEst["type"], test.ShouldEqual, typeLinVel)
+	test.That(t, linEst["ks"], test.ShouldAlmostEqual, 0.1, 1e-6)
+	test.That(t, linEst["kv"], test.ShouldAlmostEqual, 0.8, 1e-6)
+	angEst, ok := estimates[1].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, angEst["ks"], test.ShouldAlmostEqual, 0.05, 1e-6)
+	test.That(t, angEst["kv"], test.ShouldAlmostEqual, 0.01, 1e-6)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.Feedforward = []FeedforwardConfig{{Type: typeLinVel, KV: 1}, {Type: typeLinVel, KV: 2}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.Feedforward = []FeedforwardConfig{{Type: typeAngVel, KS: -1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.Feedforward = []FeedforwardConfig{{Type: typeLatVel, KV: 1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestGainSchedule(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	var gs *gainSchedule
+	band, changed := gs.selectBand(0, 1000)
+	test.That(t, band, test.ShouldEqual, 0)
+	test.That(t, changed, test.ShouldBeFalse)
+	test.That(t, gs.transfer(0, 0.3, time.Now()), test.ShouldEqual, 0.3)
+
+	// bands are sorted by speed, and a band is only left once the speed is clearly below it
+	gs = newGainSchedule([]GainBandConfig{
+		{Type: typeLinVel, MinSpeed: 500, P: 2, I: 2},
+		{Type: typeLinVel, MinSpeed: 100, P: 1, I: 1},
+		{Type: typeAngVel, MinSpeed: 30, P: 3, I: 3},
+	})
+	test.That(t, gs.numBands(0), test.ShouldEqual, 2)
+	test.That(t, gs.band(0, 1).MinSpeed, test.ShouldEqual, 100)
+	band, changed = gs.selectBand(0, 50)
+	test.That(t, band, test.ShouldEqual, 0)
+	test.That(t, changed, test.ShouldBeFalse)
+	band, _ = gs.selectBand(0, 600)
+	test.That(t, band, test.ShouldEqual, 2)
+	gs.switchBand(0, 2, 0, 0, 0, false, time.Now())
+	band, changed = gs.selectBand(0, 480)
+	test.That(t, band, test.ShouldEqual, 2)
+	test.That(t, changed, test.ShouldBeFalse)
+	band, _ = gs.selectBand(0, 440)
+	test.That(t, band, test.ShouldEqual, 1)
+
+	// the integral of the previous gains is carried over and fades out
+	start := time.Now()
+	gs.transfer(0, 0.5, start)
+	gs.switchBand(0, 1, 0.2/rPiGain, 1, 0, true, start)
+	test.That(t, gs.transfer(0, 0.1, start), test.ShouldAlmostEqual, 0.4)
+	test.That(t, gs.transfer(0, 0.1, start.Add(bumplessTransferTime/2)), test.ShouldAlmostEqual, 0.25)
+	test.That(t, gs.transfer(0, 0.1, start.Add(bumplessTransferTime)), test.ShouldAlmostEqual, 0.1)
+
+	// the base switches the gains of its running loop as the setpoint changes
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.GainSchedule = []GainBandConfig{{Type: typeLinVel, MinSpeed: 400, P: 0.7, I: 0.9}}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	linearGains := func() control.PIDConfig {
+		blk, err := sb.loop.ConfigAt(ctx, sb.blockNames[pidBlockType][0])
+		test.That(t, err, test.ShouldBeNil)
+		return *blk.Attribute["PIDSets"].([]*control.PIDConfig)[0]
+	}
+	linearKi := func() float64 {
+		sb.saturation.mu.Lock()
+		defer sb.saturation.mu.Unlock()
+		return sb.saturation.ki[0]
+	}
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 500}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, linearGains().P, test.ShouldEqual, 0.7)
+	test.That(t, linearKi(), test.ShouldEqual, 0.9)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, linearGains().P, test.ShouldEqual, sb.configPIDVals[0].P)
+	test.That(t, linearKi(), test.ShouldEqual, sb.configPIDVals[0].I)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getPID: true})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp["gain_schedule"], test.ShouldResemble, conf.GainSchedule)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// bands are not tuned on reconfigure, so they need gains
+	conf.GainSchedule = []GainBandConfig{{Type: typeLinVel, MinSpeed: 400}}
+	_, err = conf.Validate("path")
+	test.That(t, err.Error(), test.ShouldContainSubstring, "cannot have all zero gains")
+
+	// a band is tuned with the start_tuning DoCommand
+	conf.GainSchedule = []GainBandConfig{{Type: typeLinVel, MinSpeed: 400, P: 0.7, I: 0.9}}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	// without feedforward gains, a band needs a step_pct to be tuned at
+	_, err = b.DoCommand(ctx, map[string]interface{}{startTuning: map[string]interface{}{"axis": typeLinVel, "all_bands": true}})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = b.DoCommand(ctx, map[string]interface{}{startTuning: map[string]interface{}{"axis": typeLinVel, "band": 2.}})
+	test.That(t, err, test.ShouldNotBeNil)
+	resp, err = b.DoCommand(ctx, map[string]interface{}{startTuning: map[string]interface{}{
+		"axis": typeLinVel, "band": 1., "step_pct": 0.5,
+	}})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, resp[startTuning], test.ShouldEqual, "tuning linear_velocity gain_schedule band 1")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	tr, err := parseTuningRequest(map[string]interface{}{"axis": typeLinVel, "all_bands": true})
+	test.That(t, err, test.ShouldBeNil)
+	test.That(t, tr.stepPct, test.ShouldEqual, 0)
+	_, err = parseTuningRequest(map[string]interface{}{"axis": typeLinVel, "band": 1.5})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseTuningRequest(map[string]interface{}{"axis": typeLinVel, "all_bands": true, "step_pct": 0.3})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	conf.GainSchedule = []GainBandConfig{{Type: typeLinVel, MinSpeed: 400, P: 1}, {Type: typeLinVel, MinSpeed: 400, P: 1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.GainSchedule = []GainBandConfig{{Type: typeAngVel, MinSpeed: 0, P: 1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.GainSchedule = []GainBandConfig{{Type: typeLatVel, MinSpeed: 100, P: 1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestSaturation(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	s := &saturation{}
+	power, events := s.limit(0, 0.5)
+	test.That(t, power, test.ShouldEqual, 0.5)
+	test.That(t, events, test.ShouldEqual, 0)
+	power, events = s.limit(0, -1.5)
+	test.That(t, power, test.ShouldEqual, -1)
+	test.That(t, events, test.ShouldEqual, 1)
+	// staying saturated is a single event
+	_, events = s.limit(0, -2)
+	test.That(t, events, test.ShouldEqual, 0)
+	s.limit(0, 0)
+	_, events = s.limit(0, 3)
+	test.That(t, events, test.ShouldEqual, 2)
+	test.That(t, s.counts(), test.ShouldResemble, [3]int{2, 0, 0})
+
+	// the integrator grows normally until the output saturates, then its growth is held back
+	s.setIntegralGain(1, 1/rPiGain)
+	test.That(t, s.holdWindup(1, 0.5, 0, 1, 0.001), test.ShouldAlmostEqual, 0.5)
+	test.That(t, s.holdWindup(1, 1.2, 0, 1, 0.1), test.ShouldAlmostEqual, 1.1)
+	test.That(t, s.holdWindup(1, 1.3, 0, 1, 0.1), test.ShouldAlmostEqual, 1.1)
+	// growth back out of saturation is not held
+	test.That(t, s.holdWindup(1, 1.2, 0, -1, 0.1), test.ShouldAlmostEqual, 1.0)
+	test.That(t, s.takeHeld(1), test.ShouldAlmostEqual, -0.2)
+	test.That(t, s.holdWindup(1, 0.5, 0, 1, 0.001), test.ShouldAlmostEqual, 0.5)
+
+	// the base never sends more than full power and reports each saturation
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	var mu sync.Mutex
+	var maxPower float64
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		maxPower = math.Max(maxPower, math.Max(math.Abs(linear.Y), math.Abs(angular.Z)))
+		return nil
+	}
+	cfg.ConvertedAttributes.(*SCBConfig).Feedforward = []FeedforwardConfig{{Type: typeLinVel, KS: 2}}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	// the integral gains are noted when the loop starts rather than looked up on every tick
+	sb.saturation.mu.Lock()
+	test.That(t, sb.saturation.ki, test.ShouldResemble, [2]float64{0.5, 0.5})
+	sb.saturation.mu.Unlock()
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		tb.Helper()
+		test.That(tb, sb.diagnostics(ctx)["linear_saturation_events"], test.ShouldEqual, 1)
+	})
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	mu.Lock()
+	test.That(t, maxPower, test.ShouldEqual, 1)
+	mu.Unlock()
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestDeadband(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	test.That(t, applyDeadband(0.5, 0, 1), test.ShouldEqual, 0.5)
+	test.That(t, applyDeadband(0.5, 0.2, 0), test.ShouldEqual, 0.5)
+	test.That(t, applyDeadband(0, 0.2, 1), test.ShouldEqual, 0)
+	test.That(t, applyDeadband(0.5, 0.2, 1), test.ShouldAlmostEqual, 0.6)
+	test.That(t, applyDeadband(-1, 0.2, 1), test.ShouldAlmostEqual, -1)
+
+	_, err := parseDeadbandRequest(map[string]interface{}{"axis": typeLatVel})
+	test.That(t, err, test.ShouldNotBeNil)
+	_, err = parseDeadbandRequest(map[string]interface{}{"linear_threshold_mm_per_sec": -1.})
+	test.That(t, err, test.ShouldNotBeNil)
+
+	// the calibration ramps each axis until the base moves and applies the deadband it found
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).ControlFreq = 100
+	plant := func(power, deadband, gain float64) float64 { return math.Max(0, power-deadband) * gain }
+	var mu sync.Mutex
+	var power r3.Vector
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.SetPowerFunc = func(ctx context.Context, linear, angular r3.Vector, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		power = r3.Vector{X: linear.Y, Y: angular.Z}
+		return nil
+	}
+	velSensor, ok := deps[movementsensor.Named("setvel1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	velSensor.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return r3.Vector{Y: plant(power.X, 0.2, 1)}, nil
+	}
+	velSensor.AngularVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.AngularVelocity, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return spatialmath.AngularVelocity{Z: plant(power.Y, 0.1, 100)}, nil
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{calibrateDeadband: map[string]interface{}{"ramp_sec": 0.5}})
+	test.That(t, err, test.ShouldBeNil)
+	deadbands, ok := resp[calibrateDeadband].([]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, deadbands, test.ShouldHaveLength, 2)
+	linDB, ok := deadbands[0].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, linDB["type"], test.ShouldEqual, typeLinVel)
+	test.That(t, linDB["power"], test.ShouldAlmostEqual, 0.21, 0.03)
+	test.That(t, sb.deadband[0], test.ShouldEqual, linDB["power"])
+	angDB, ok := deadbands[1].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, angDB["power"], test.ShouldAlmostEqual, 0.12, 0.03)
+
+	// the calibrated deadbands only last until a reconfigure, unless they are copied into the config
+	test.That(t, b.Reconfigure(ctx, deps, cfg), test.ShouldBeNil)
+	test.That(t, sb.deadband, test.ShouldResemble, [2]float64{})
+	linPower, ok := linDB["power"].(float64)
+	test.That(t, ok, test.ShouldBeTrue)
+	angPower, ok := angDB["power"].(float64)
+	test.That(t, ok, test.ShouldBeTrue)
+	cfg.ConvertedAttributes.(*SCBConfig).Deadband = []DeadbandConfig{{Type: typeLinVel, Power: linPower}, {Type: typeAngVel, Power: angPower}}
+	test.That(t, b.Reconfigure(ctx, deps, cfg), test.ShouldBeNil)
+	test.That(t, sb.deadband, test.ShouldResemble, [2]float64{linPower, angPower})
+
+	// a base that never moves fails the calibration
+	_, err = b.DoCommand(ctx, map[string]interface{}{calibrateDeadband: map[string]interface{}{
+		"axis": typeLinVel, "ramp_sec": 0.2, "max_power": 0.1,
+	}})
+	test.That(t, err, test.ShouldNotBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	conf := cfg.ConvertedAttributes.(*SCBConfig)
+	conf.Deadband = []DeadbandConfig{{Type: typeLinVel, Power: 1}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+	conf.Deadband = []DeadbandConfig{{Type: typeAngVel, Power: 0.1}, {Type: typeAngVel, Power: 0.2}}
+	_, err = conf.Validate("path")
+	test.That(t, err, test.ShouldNotBeNil)
+}
+
+func TestStallDetection(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	s := &safetyMonitor{}
+	s.configure(safetyConfig{stall: stallConfig{window: time.Second, power: 0.5, linearMmPerSec: 10, angularDegsPerSec: 2}})
+	start := time.Now()
+	test.That(t, s.checkStall([2]float64{0.8, 0}, [2]float64{}, start), test.ShouldBeNil)
+	// moving resets the window
+	test.That(t, s.checkStall([2]float64{0.8, 0}, [2]float64{50, 0}, start.Add(600*time.Millisecond)), test.ShouldBeNil)
+	test.That(t, s.checkStall([2]float64{0.8, 0}, [2]float64{}, start.Add(700*time.Millisecond)), test.ShouldBeNil)
+	test.That(t, s.checkStall([2]float64{0.8, 0}, [2]float64{}, start.Add(1500*time.Millisecond)), test.ShouldBeNil)
+	fault := s.checkStall([2]float64{0.8, 0}, [2]float64{}, start.Add(1700*time.Millisecond))
+	test.That(t, fault, test.ShouldNotBeNil)
+	test.That(t, errors.Is(fault, ErrStalled), test.ShouldBeTrue)
+	test.That(t, fault.Error(), test.ShouldContainSubstring, typeLinVel)
+	// low power never stalls
+	s.resetChecks()
+	test.That(t, s.checkStall([2]float64{0, 0.2}, [2]float64{}, start), test.ShouldBeNil)
+	test.That(t, s.checkStall([2]float64{0, 0.2}, [2]float64{}, start.Add(2*time.Second)), test.ShouldBeNil)
+
+	// a base that is pushed against a wall is stopped and the stall is returned by the command that was running
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).StallWindowSec = 0.3
+	cfg.ConvertedAttributes.(*SCBConfig).Feedforward = []FeedforwardConfig{{Type: typeLinVel, KS: 0.8}}
+	var stops int
+	var mu sync.Mutex
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.StopFunc = func(ctx context.Context, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stops++
+		return nil
+	}
+	lockSensors(deps)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	err = b.MoveStraight(ctx, 1000, 100, nil)
+	test.That(t, errors.Is(err, ErrStalled), test.ShouldBeTrue)
+	test.That(t, sb.loop.Running(), test.ShouldBeFalse)
+	mu.Lock()
+	test.That(t, stops, test.ShouldBeGreaterThan, 0)
+	mu.Unlock()
+
+	// a stall after SetVelocity has returned is returned by the next SetVelocity, which does not move the base
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		tb.Helper()
+		test.That(tb, sb.loop.Running(), test.ShouldBeFalse)
+	})
+	err = b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil)
+	test.That(t, errors.Is(err, ErrStalled), test.ShouldBeTrue)
+	test.That(t, sb.loop.Running(), test.ShouldBeFalse)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getSafetyStatus: true})
+	test.That(t, err, test.ShouldBeNil)
+	status, ok := resp[getSafetyStatus].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, status["stall_detection"], test.ShouldBeTrue)
+	lastFault, ok := status["last_fault"].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, lastFault["check"], test.ShouldEqual, safetyStall)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestCommandWatchdog(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).VelocityCommandTimeout = 0.3
+	var stops int
+	var mu sync.Mutex
+	testBase, ok := deps[base.Named("test_base")].(*inject.Base)
+	test.That(t, ok, test.ShouldBeTrue)
+	testBase.StopFunc = func(ctx context.Context, extra map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stops++
+		return nil
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	watchdogFired := func() interface{} {
+		resp, err := b.DoCommand(ctx, map[string]interface{}{getSafetyStatus: true})
+		test.That(t, err, test.ShouldBeNil)
+		status, ok := resp[getSafetyStatus].(map[string]interface{})
+		test.That(t, ok, test.ShouldBeTrue)
+		return status["watchdog_fired"]
+	}
+
+	// commands that keep arriving keep the base driving
+	for i := 0; i < 5; i++ {
+		test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{Z: 10}, nil), test.ShouldBeNil)
+		time.Sleep(100 * time.Millisecond)
+	}
+	test.That(t, sb.loop.Running(), test.ShouldBeTrue)
+	test.That(t, watchdogFired(), test.ShouldBeFalse)
+
+	// a session that goes silent is ramped down to zero and stopped
+	mu.Lock()
+	stops = 0
+	mu.Unlock()
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		tb.Helper()
+		test.That(tb, sb.loop.Running(), test.ShouldBeFalse)
+	})
+	test.That(t, watchdogFired(), test.ShouldBeTrue)
+	current, _ := sb.history.snapshot(0)
+	test.That(t, current.linearSetpoint, test.ShouldEqual, 0)
+	test.That(t, current.angularSetpoint, test.ShouldEqual, 0)
+	mu.Lock()
+	test.That(t, stops, test.ShouldEqual, 1)
+	mu.Unlock()
+
+	// the next command drives again, and Stop disarms the watchdog
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, watchdogFired(), test.ShouldBeFalse)
+	test.That(t, sb.loop.Running(), test.ShouldBeTrue)
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	time.Sleep(500 * time.Millisecond)
+	test.That(t, watchdogFired(), test.ShouldBeFalse)
+
+	// a SetVelocity of zero holds the base still without arming the watchdog
+	test.That(t, b.SetVelocity(ctx, r3.Vector{}, r3.Vector{}, nil), test.ShouldBeNil)
+	time.Sleep(500 * time.Millisecond)
+	test.That(t, watchdogFired(), test.ShouldBeFalse)
+	test.That(t, sb.loop.Running(), test.ShouldBeTrue)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestStaleSensor(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	s := &safetyMonitor{}
+	s.configure(safetyConfig{staleSensors: []StaleSensorConfig{{Type: staleHeading, Ticks: 3}, {Type: stalePosition, MaxAgeSec: 1}}})
+	period := 100 * time.Millisecond
+	start := time.Now()
+	// readings that do not change while the base is at rest are not stale
+	for i := 0; i < 5; i++ {
+		test.That(t, s.checkStale(staleHeading, "imu", []float64{10}, start.Add(time.Duration(i)*period), period), test.ShouldBeNil)
+	}
+	s.notePower([3]float64{0.5, 0, 0}, start.Add(5*period))
+	test.That(t, s.checkStale(staleHeading, "imu", []float64{10}, start.Add(5*period), period), test.ShouldBeNil)
+	s.notePower([3]float64{0.5, 0, 0}, start.Add(6*period))
+	test.That(t, s.checkStale(staleHeading, "imu", []float64{10}, start.Add(6*period), period), test.ShouldBeNil)
+	// a second read within the same tick is not another tick
+	test.That(t, s.checkStale(staleHeading, "imu", []float64{10}, start.Add(6*period+time.Millisecond), period), test.ShouldBeNil)
+	s.notePower([3]float64{0.5, 0, 0}, start.Add(7*period))
+	fault := s.checkStale(staleHeading, "imu", []float64{10}, start.Add(7*period), period)
+	test.That(t, fault, test.ShouldNotBeNil)
+	test.That(t, errors.Is(fault, ErrStaleSensor), test.ShouldBeTrue)
+	test.That(t, fault.Error(), test.ShouldContainSubstring, "heading reading of imu")
+	// a reading that changes is not stale, and one that is too old is
+	test.That(t, s.checkStale(stalePosition, "gps", []float64{1, 2}, start.Add(8*period), period), test.ShouldBeNil)
+	test.That(t, s.checkStale(stalePosition, "gps", []float64{1, 3}, start.Add(8*period), period), test.ShouldBeNil)
+	s.notePower([3]float64{0, 0.5, 0}, start.Add(19*period))
+	fault = s.checkStale(stalePosition, "gps", []float64{1, 3}, start.Add(19*period), period)
+	test.That(t, fault, test.ShouldNotBeNil)
+	test.That(t, fault.Error(), test.ShouldContainSubstring, "position reading of gps")
+	// roles without a check are never stale
+	test.That(t, s.checkStale(staleVelocity, "enc", []float64{0, 0}, start.Add(19*period), period), test.ShouldBeNil)
+
+	// a frozen velocity sensor stops the base and the running command returns an error naming it
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).StaleSensor = []StaleSensorConfig{{Type: staleVelocity, Ticks: 5}}
+	cfg.ConvertedAttributes.(*SCBConfig).Feedforward = []FeedforwardConfig{{Type: typeLinVel, KS: 0.5}}
+	velSensor, ok := deps[movementsensor.Named("setvel1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	velSensor.LinearVelocityFunc = func(ctx context.Context, extra map[string]interface{}) (r3.Vector, error) {
+		return r3.Vector{Y: 0.05}, nil
+	}
+	lockSensors(deps)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+	err = b.MoveStraight(ctx, 1000, 100, nil)
+	test.That(t, errors.Is(err, ErrStaleSensor), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "setvel1")
+	test.That(t, sb.loop.Running(), test.ShouldBeFalse)
+
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getSafetyStatus: true})
+	test.That(t, err, test.ShouldBeNil)
+	status, ok := resp[getSafetyStatus].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, status["stale_sensor_checks"], test.ShouldResemble, []interface{}{staleVelocity})
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestGeofence(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	// positions in meters, with lat as Y and lng as X
+	local := func(from, to *geo.Point) r3.Vector {
+		return r3.Vector{X: (to.Lng() - from.Lng()) * 1000, Y: (to.Lat() - from.Lat()) * 1000}
+	}
+	square := newGeofence(&GeofenceConfig{Polygon: []GeoPointConfig{{0, 0}, {0, 10}, {10, 10}, {10, 0}}})
+	test.That(t, square.outsideMm(geo.NewPoint(5, 5), local), test.ShouldEqual, 0)
+	test.That(t, square.outsideMm(geo.NewPoint(5, 12), local), test.ShouldAlmostEqual, 2000)
+	test.That(t, square.outsideMm(geo.NewPoint(-4, -3), local), test.ShouldAlmostEqual, 5000)
+	circle := newGeofence(&GeofenceConfig{Home: &GeoPointConfig{}, RadiusM: 5})
+	test.That(t, circle.outsideMm(geo.NewPoint(3, 0), local), test.ShouldEqual, 0)
+	test.That(t, circle.outsideMm(geo.NewPoint(0, 8), local), test.ShouldAlmostEqual, 3000)
+	test.That(t, circle.marginMm, test.ShouldEqual, defaultGeofenceMarginM*1000)
+	test.That(t, square.clearanceMm(geo.NewPoint(5, 7), local), test.ShouldAlmostEqual, 3000)
+	test.That(t, circle.clearanceMm(geo.NewPoint(0, 8), local), test.ShouldEqual, 0)
+
+	// a base that was inside the fence is stopped as soon as it leaves it, whatever the margin
+	s := &safetyMonitor{}
+	s.configure(safetyConfig{geofence: true})
+	s.noteOutside(0)
+	test.That(t, s.checkGeofence(1000, time.Now()), test.ShouldBeNil)
+	s.noteOutside(50)
+	test.That(t, errors.Is(s.checkGeofence(1000, time.Now()), ErrGeofence), test.ShouldBeTrue)
+
+	badFences := []*GeofenceConfig{
+		{},
+		{Polygon: []GeoPointConfig{{0, 0}, {0, 1}}},
+		{Home: &GeoPointConfig{}},
+		{Home: &GeoPointConfig{}, RadiusM: 5, Polygon: []GeoPointConfig{{0, 0}, {0, 1}, {1, 1}}},
+	}
+	for _, gf := range badFences {
+		cfg := &SCBConfig{Geofence: gf}
+		test.That(t, cfg.validateGeofence(), test.ShouldNotBeNil)
+	}
+
+	// a geodetic base with a fence of 10 m around where it starts
+	deps, cfg := msDependencies(t, []string{"setvel1", "position1", "compass1"})
+	cfg.ConvertedAttributes.(*SCBConfig).Geofence = &GeofenceConfig{Home: &GeoPointConfig{}, RadiusM: 10}
+	cfg.ConvertedAttributes.(*SCBConfig).Feedforward = []FeedforwardConfig{{Type: typeLinVel, KS: 0.2}}
+	metersNorth := func(m float64) *geo.Point { return geo.NewPoint(0, 0).PointAtDistanceAndBearing(m/1000, 0) }
+	var mu sync.Mutex
+	pos := metersNorth(0)
+	posSensor, ok := deps[movementsensor.Named("position1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	posSensor.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return pos, 0, nil
+	}
+	moveTo := func(m float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		pos = metersNorth(m)
+	}
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	// targets outside the fence are refused
+	_, err = b.DoCommand(ctx, map[string]interface{}{moveToPose: map[string]interface{}{"x_mm": 20000.}})
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "move_to_pose target")
+	err = b.MoveStraight(ctx, 20000, 500, nil)
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "MoveStraight target")
+	wpReq := map[string]interface{}{"waypoints": []interface{}{map[string]interface{}{"lat": 0.01, "lng": 0.}}}
+	_, err = b.DoCommand(ctx, map[string]interface{}{followWaypoints: wpReq})
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+
+	// a base that drives out of the fence is stopped
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	moveTo(5)
+	time.Sleep(300 * time.Millisecond)
+	moveTo(12)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		tb.Helper()
+		test.That(tb, sb.loop.Running(), test.ShouldBeFalse)
+	})
+	err = b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil)
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+
+	// but may be driven back in, and is stopped again if it drives further out
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: -100}, r3.Vector{}, nil), test.ShouldBeNil)
+	time.Sleep(300 * time.Millisecond)
+	moveTo(11.5)
+	time.Sleep(300 * time.Millisecond)
+	test.That(t, sb.loop.Running(), test.ShouldBeTrue)
+	moveTo(13)
+	utilstestutils.WaitForAssertion(t, func(tb testing.TB) {
+		tb.Helper()
+		test.That(tb, sb.loop.Running(), test.ShouldBeFalse)
+	})
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getSafetyStatus: true})
+	test.That(t, err, test.ShouldBeNil)
+	status, ok := resp[getSafetyStatus].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, status["geofence"], test.ShouldBeTrue)
+	test.That(t, status["outside_geofence_m"], test.ShouldAlmostEqual, 3, 0.01)
+
+	// a SetVelocity heading out of the fence is refused, one heading along inside it is not
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	moveTo(9.5)
+	err = b.SetVelocity(ctx, r3.Vector{Y: 1000}, r3.Vector{}, nil)
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "SetVelocity target")
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: -1000}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+
+	// without a compass the course of a MoveStraight is unknown, so only moves that cannot leave the fence are allowed
+	deps, cfg = msDependencies(t, []string{"setvel1", "position1"})
+	cfg.ConvertedAttributes.(*SCBConfig).Geofence = &GeofenceConfig{Home: &GeoPointConfig{}, RadiusM: 10}
+	posSensor, ok = deps[movementsensor.Named("position1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	posSensor.PositionFunc = func(ctx context.Context, extra map[string]interface{}) (*geo.Point, float64, error) {
+		return metersNorth(6), 0, nil
+	}
+	b, err = newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	err = b.MoveStraight(ctx, 5000, 500, nil)
+	test.That(t, errors.Is(err, ErrGeofence), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "course is unknown")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
+
+func TestTilt(t *testing.T) {
+	ctx := context.Background()
+	logger := logging.NewTestLogger(t)
+
+	for _, cfg := range []*SCBConfig{{MaxRollDeg: -1}, {MaxPitchDeg: 91}} {
+		test.That(t, cfg.validateSafety(), test.ShouldNotBeNil)
+	}
+	deps, cfg := msDependencies(t, []string{"setvel1"})
+	cfg.ConvertedAttributes.(*SCBConfig).MaxRollDeg = 20
+	_, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldNotBeNil)
+
+	deps, cfg = msDependencies(t, []string{"setvel1", "orientation1"})
+	cfg.ConvertedAttributes.(*SCBConfig).MaxRollDeg = 20
+	cfg.ConvertedAttributes.(*SCBConfig).MaxPitchDeg = 15
+	var mu sync.Mutex
+	var pitchDeg float64
+	orientSensor, ok := deps[movementsensor.Named("orientation1")].(*inject.MovementSensor)
+	test.That(t, ok, test.ShouldBeTrue)
+	orientSensor.OrientationFunc = func(ctx context.Context, extra map[string]interface{}) (spatialmath.Orientation, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &spatialmath.EulerAngles{Pitch: rdkutils.DegToRad(pitchDeg)}, nil
+	}
+	pitchTo := func(deg float64) {
+		mu.Lock()
+		defer mu.Unlock()
+		pitchDeg = deg
+	}
+	lockSensors(deps)
+	b, err := newSCB(ctx, deps, cfg, logger)
+	test.That(t, err, test.ShouldBeNil)
+	sb, ok := b.(*sensorBase)
+	test.That(t, ok, test.ShouldBeTrue)
+
+	// a base that tips over while driving is stopped and the running command returns the tilt
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- b.MoveStraight(ctx, 10000, 100, nil)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	pitchTo(25)
+	select {
+	case err = <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("MoveStraight did not stop when the base tilted")
+	}
+	test.That(t, errors.Is(err, ErrTilted), test.ShouldBeTrue)
+	test.That(t, sb.loop.Running(), test.ShouldBeFalse)
+
+	// commands are refused until the base is back within the level fraction of its limits
+	err = b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil)
+	test.That(t, errors.Is(err, ErrTilted), test.ShouldBeTrue)
+	pitchTo(14)
+	test.That(t, errors.Is(b.SetPower(ctx, r3.Vector{Y: 0.5}, r3.Vector{}, nil), ErrTilted), test.ShouldBeTrue)
+	resp, err := b.DoCommand(ctx, map[string]interface{}{getSafetyStatus: true})
+	test.That(t, err, test.ShouldBeNil)
+	status, ok := resp[getSafetyStatus].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, status["tilted"], test.ShouldBeTrue)
+	test.That(t, status["pitch_deg"], test.ShouldAlmostEqual, 14)
+	lastFault, ok := status["last_fault"].(map[string]interface{})
+	test.That(t, ok, test.ShouldBeTrue)
+	test.That(t, lastFault["check"], test.ShouldEqual, safetyTilt)
+
+	pitchTo(5)
+	test.That(t, b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil), test.ShouldBeNil)
+	test.That(t, sb.loop.Running(), test.ShouldBeTrue)
+
+	// a base found tilted by a new command is stopped before the command is refused
+	test.That(t, b.Stop(ctx, nil), test.ShouldBeNil)
+	pitchTo(-30)
+	err = b.SetVelocity(ctx, r3.Vector{Y: 100}, r3.Vector{}, nil)
+	test.That(t, errors.Is(err, ErrTilted), test.ShouldBeTrue)
+	test.That(t, err.Error(), test.ShouldContainSubstring, "past the limits")
+	test.That(t, b.Close(ctx), test.ShouldBeNil)
+}
@@ -0,0 +1,138 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	rdkutils "go.viam.com/rdk/utils"
+)
+
+const (
+	safetyTilt = "tilt"
+	// tiltLevelFraction is the fraction of its limit the roll and pitch have to be back within for a tilted base
+	// to count as level again, so that a base resting right at a limit does not flip between accepting
+	// and refusing commands
+	tiltLevelFraction = 0.8
+)
+
+// ErrTilted matches, with errors.Is, the error a command returns when the base tilted past its roll or pitch limit.
+var ErrTilted = &SafetyStopError{Check: safetyTilt}
+
+// tiltConfig holds the roll and pitch limits of the base in degrees. A zero limit leaves its axis unchecked.
+type tiltConfig struct {
+	maxRollDeg  float64
+	maxPitchDeg float64
+}
+
+// enabled returns whether either limit is set.
+func (tc tiltConfig) enabled() bool {
+	return tc.maxRollDeg > 0 || tc.maxPitchDeg > 0
+}
+
+// exceeds returns whether a roll and pitch are past the given fraction of the limits.
+func (tc tiltConfig) exceeds(rollDeg, pitchDeg, fraction float64) bool {
+	return (tc.maxRollDeg > 0 && math.Abs(rollDeg) > tc.maxRollDeg*fraction) ||
+		(tc.maxPitchDeg > 0 && math.Abs(pitchDeg) > tc.maxPitchDeg*fraction)
+}
+
+// tiltLimits returns the roll and pitch limits of the base.
+func (s *safetyMonitor) tiltLimits() tiltConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conf.tilt
+}
+
+// noteTilt records the latest roll and pitch of the base in degrees, and returns whether
+// a tilted base has just come back level.
+func (s *safetyMonitor) noteTilt(rollDeg, pitchDeg float64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollDeg, s.pitchDeg, s.tiltRead = rollDeg, pitchDeg, true
+	if s.tilted && !s.conf.tilt.exceeds(rollDeg, pitchDeg, tiltLevelFraction) {
+		s.tilted = false
+		return true
+	}
+	return false
+}
+
+// checkTilt returns a fault once the latest roll or pitch is past its limit. The base then counts as tilted,
+// and no further fault is raised until it has been level again.
+func (s *safetyMonitor) checkTilt(now time.Time) *SafetyStopError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tilted || !s.tiltRead || !s.conf.tilt.exceeds(s.rollDeg, s.pitchDeg, 1) {
+		return nil
+	}
+	s.tilted = true
+	return &SafetyStopError{
+		Check: safetyTilt,
+		Detail: fmt.Sprintf("the base rolled %.1f deg and pitched %.1f deg, past the limits of %v deg roll and %v deg pitch",
+			s.rollDeg, s.pitchDeg, s.conf.tilt.maxRollDeg, s.conf.tilt.maxPitchDeg),
+		Time: now,
+	}
+}
+
+// tiltState returns whether the base is tilted along with its latest roll and pitch in degrees.
+func (s *safetyMonitor) tiltState() (bool, float64, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tilted, s.rollDeg, s.pitchDeg
+}
+
+// readTilt reads the roll and pitch of the base from the orientation sensor and records them.
+func (sb *sensorBase) readTilt(ctx context.Context) error {
+	orient, err := sb.orientation.Orientation(ctx, nil)
+	if err != nil {
+		return err
+	}
+	euler := orient.EulerAngles()
+	if sb.safety.noteTilt(rdkutils.RadToDeg(euler.Roll), rdkutils.RadToDeg(euler.Pitch)) {
+		sb.logger.CInfo(ctx, "the base is level again, accepting commands")
+	}
+	return nil
+}
+
+// noteTilt reads the tilt of the base for the control loop to check, if roll or pitch limits are configured.
+// It is called from State, so a failed read only skips the tick.
+func (sb *sensorBase) noteTilt(ctx context.Context) {
+	if !sb.safety.tiltLimits().enabled() || sb.orientation == nil {
+		return
+	}
+	if err := sb.readTilt(ctx); err != nil {
+		sb.logger.CDebugf(ctx, "could not read the orientation to check the tilt: %v", err)
+	}
+}
+
+// checkLevel refuses a new command while the base is tilted. A base found past its limits is stopped first.
+// The caller must not hold sb.mu.
+func (sb *sensorBase) checkLevel(ctx context.Context) error {
+	if !sb.safety.tiltLimits().enabled() || sb.orientation == nil {
+		return nil
+	}
+	if err := sb.readTilt(ctx); err != nil {
+		return fmt.Errorf("could not read the orientation to check the tilt of the base: %w", err)
+	}
+	now := time.Now()
+	if fault := sb.safety.checkTilt(now); fault != nil {
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		if err := sb.safetyStop(ctx, fault); err != nil {
+			sb.logger.CError(ctx, err)
+		}
+		// the fault is returned by the command itself, so the next SetVelocity does not need to return it
+		sb.safety.clearUnreported()
+		return fault
+	}
+	tilted, roll, pitch := sb.safety.tiltState()
+	if !tilted {
+		return nil
+	}
+	sb.safety.clearUnreported()
+	return &SafetyStopError{
+		Check:  safetyTilt,
+		Detail: fmt.Sprintf("the base is still tilted at %.1f deg roll and %.1f deg pitch, commands are refused until it is level", roll, pitch),
+		Time:   now,
+	}
+}
@@ -0,0 +1,167 @@
+package controlledcomponents
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/control"
+	"go.viam.com/utils"
+)
+
+const (
+	// moduleDataEnv is set by viam-server to a directory the module can persist data in.
+	moduleDataEnv         = "VIAM_MODULE_DATA"
+	defaultGainsFileName  = "tuned_gains.json"
+	tunedGainsPollTime    = time.Second
+	tunedGainsPermissions = 0o600
+)
+
+// gainsFileMu serializes reads and writes of tuned gains files, which may be shared by several bases.
+var gainsFileMu sync.Mutex
+
+// tunedGainsPath returns the file tuned gains are saved to, or an empty string if gains should not be saved.
+func tunedGainsPath(conf *SCBConfig) string {
+	if conf.TunedGainsFile != "" {
+		return conf.TunedGainsFile
+	}
+	if dir := os.Getenv(moduleDataEnv); dir != "" {
+		return filepath.Join(dir, defaultGainsFileName)
+	}
+	return ""
+}
+
+// readGainsFile returns every base's saved gains. A missing file is not an error.
+func readGainsFile(path string) (map[string][]control.PIDConfig, error) {
+	gains := map[string][]control.PIDConfig{}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return gains, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &gains); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse tuned gains file %s", path)
+	}
+	return gains, nil
+}
+
+// loadTunedGains returns the gains saved for the named base.
+func loadTunedGains(path, baseName string) ([]control.PIDConfig, error) {
+	gainsFileMu.Lock()
+	defer gainsFileMu.Unlock()
+	gains, err := readGainsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return gains[baseName], nil
+}
+
+// saveTunedGains merges the tuned gains into the gains already saved for the named base.
+// Gains that still need tuning are ignored, and saved gains of the same type are replaced.
+func saveTunedGains(path, baseName string, tuned []control.PIDConfig) error {
+	gainsFileMu.Lock()
+	defer gainsFileMu.Unlock()
+	gains, err := readGainsFile(path)
+	if err != nil {
+		return err
+	}
+
+	saved := gains[baseName]
+	for _, pidConf := range tuned {
+		if pidConf.NeedsAutoTuning() {
+			continue
+		}
+		replaced := false
+		for i := range saved {
+			if saved[i].Type == pidConf.Type {
+				saved[i] = pidConf
+				replaced = true
+			}
+		}
+		if !replaced {
+			saved = append(saved, pidConf)
+		}
+	}
+	gains[baseName] = saved
+
+	data, err := json.MarshalIndent(gains, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash cannot leave a partially written gains file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, tunedGainsPermissions); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// applySavedGains replaces any configured gains that need tuning with gains saved by a previous tuning run.
+func (sb *sensorBase) applySavedGains(ctx context.Context) {
+	if sb.gainsFile == "" {
+		return
+	}
+	saved, err := loadTunedGains(sb.gainsFile, sb.Name().ShortName())
+	if err != nil {
+		sb.logger.CWarnf(ctx, "could not load saved tuned gains, tuning instead: %v", err)
+		return
+	}
+
+	for i := range sb.configPIDVals {
+		if !sb.configPIDVals[i].NeedsAutoTuning() {
+			continue
+		}
+		for _, pidConf := range saved {
+			if pidConf.Type == sb.configPIDVals[i].Type && !pidConf.NeedsAutoTuning() {
+				sb.logger.CInfof(ctx, "using saved tuned gains %v from %s", pidConf, sb.gainsFile)
+				sb.configPIDVals[i] = pidConf
+			}
+		}
+	}
+}
+
+// saveGainsWhenTuned waits in the background for auto-tuning to finish, then ends the tuning run
+// and saves the tuned gains.
+func (sb *sensorBase) saveGainsWhenTuned(tunedVals *[]control.PIDConfig, needsTuning []bool, run *runLog) {
+	if sb.gainsFile == "" && run == nil {
+		return
+	}
+	path := sb.gainsFile
+	baseName := sb.Name().ShortName()
+	sb.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		for utils.SelectContextOrWait(sb.cancelCtx, tunedGainsPollTime) {
+			tuned := make([]control.PIDConfig, 0, len(needsTuning))
+			done := true
+			for i, needed := range needsTuning {
+				if !needed {
+					continue
+				}
+				done = done && !(*tunedVals)[i].NeedsAutoTuning()
+				tuned = append(tuned, (*tunedVals)[i])
+			}
+			if !done {
+				continue
+			}
+			sb.endRun(run)
+			if path == "" {
+				return
+			}
+			if err := saveTunedGains(path, baseName, tuned); err != nil {
+				sb.logger.Errorf("failed to save tuned gains to %s: %v", path, err)
+				return
+			}
+			sb.logger.Infof("saved tuned gains to %s", path)
+			return
+		}
+	}, sb.activeBackgroundWorkers.Done)
+}
@@ -0,0 +1,334 @@
+package controlledcomponents
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.viam.com/rdk/control"
+	rdkutils "go.viam.com/rdk/utils"
+	"go.viam.com/utils"
+)
+
+const (
+	pidBlockType         = "PID"
+	defaultTuneStepPct   = 0.35
+	defaultTuningTimeout = 2 * time.Minute
+	// the PID values the library uses to hold an axis that is not being tuned
+	untunedAxisGain = .001
+)
+
+var errTuningRunning = errors.New("tuning is already running, send cancel_tuning to stop it")
+
+// tuningRequest holds the options of a start_tuning DoCommand.
+type tuningRequest struct {
+	axis        int
+	stepPct     float64
+	maxDuration time.Duration
+	// band is the gain_schedule band to tune, band 0 is the control_parameters gains
+	band int
+	// allBands tunes every gain_schedule band of the axis in turn
+	allBands bool
+}
+
+// parseTuningRequest reads the options of a start_tuning DoCommand, filling in defaults for any that are missing.
+func parseTuningRequest(raw interface{}) (tuningRequest, error) {
+	tr := tuningRequest{maxDuration: defaultTuningTimeout}
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return tuningRequest{}, errors.New("start_tuning requires an object with at least an axis")
+	}
+
+	axis, _ := opts["axis"].(string)
+	switch axis {
+	case typeLinVel:
+		tr.axis = 0
+	case typeAngVel:
+		tr.axis = 1
+	case typeLatVel:
+		tr.axis = latAxis
+	default:
+		return tuningRequest{}, fmt.Errorf(
+			"start_tuning axis '%v' not accepted, axis must be 'linear_velocity', 'angular_velocity' or 'lateral_velocity'", axis)
+	}
+
+	if stepPct, ok := opts["step_pct"].(float64); ok {
+		if stepPct <= 0 || stepPct > 1 {
+			return tuningRequest{}, errors.New("start_tuning step_pct must be between 0 and 1")
+		}
+		tr.stepPct = stepPct
+	}
+	if band, ok := opts["band"].(float64); ok {
+		if band < 1 || band != math.Trunc(band) {
+			return tuningRequest{}, errors.New("start_tuning band must be a whole number of at least 1")
+		}
+		tr.band = int(band)
+	}
+	tr.allBands, _ = opts["all_bands"].(bool)
+	if tr.allBands && (tr.band != 0 || tr.stepPct != 0) {
+		return tuningRequest{}, errors.New("start_tuning all_bands cannot be combined with band or step_pct")
+	}
+	// gain_schedule bands without a step_pct are tuned at the power their feedforward gains predict
+	if tr.stepPct == 0 && tr.band == 0 && !tr.allBands {
+		tr.stepPct = defaultTuneStepPct
+	}
+	if maxDuration, ok := opts["max_duration_sec"].(float64); ok {
+		if maxDuration <= 0 {
+			return tuningRequest{}, errors.New("start_tuning max_duration_sec must be greater than 0")
+		}
+		tr.maxDuration = time.Duration(maxDuration * float64(time.Second))
+	}
+	return tr, nil
+}
+
+// startTuning begins auto-tuning one axis of the base in the background.
+// When tuning finishes, the new gains replace the gains of the running control loop.
+func (sb *sensorBase) startTuning(tr tuningRequest) error {
+	if sb.controlLoopConfig == nil {
+		return errors.New("cannot tune without a velocity sensor and control_parameters configured")
+	}
+	if tr.axis == latAxis && sb.lateral == nil {
+		return errors.New("cannot tune lateral_velocity without lateral_velocity control_parameters configured")
+	}
+	requests, err := sb.bandTuningRequests(tr)
+	if err != nil {
+		return err
+	}
+	if err := sb.checkLevel(sb.cancelCtx); err != nil {
+		return err
+	}
+	sb.mu.Lock()
+	if sb.tuneCancel != nil {
+		sb.mu.Unlock()
+		return errTuningRunning
+	}
+	if sb.tuningInProgress() {
+		sb.mu.Unlock()
+		return control.TuningInProgressErr(sb.Name().ShortName())
+	}
+	sb.mu.Unlock()
+
+	// tuning is a motion of the base, so it replaces any running operation and is cancelled by the next one
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	tuneCtx, tuneCancel := context.WithTimeout(sb.cancelCtx, tr.maxDuration)
+	opCtx, done := sb.opMgr.New(tuneCtx)
+	run := sb.startRun(opCtx, runTuning, map[string]interface{}{
+		"axis": axisName(tr.axis), "step_pct": tr.stepPct, "max_duration_sec": tr.maxDuration.Seconds(),
+		"band": tr.band, "all_bands": tr.allBands,
+	})
+
+	sb.mu.Lock()
+	sb.tuneCancel = tuneCancel
+	sb.tuneDone = make(chan struct{})
+	tuneDone := sb.tuneDone
+	sb.mu.Unlock()
+
+	sb.activeBackgroundWorkers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer sb.activeBackgroundWorkers.Done()
+		defer func() {
+			sb.mu.Lock()
+			sb.tuneCancel = nil
+			sb.tuneDone = nil
+			sb.mu.Unlock()
+			tuneCancel()
+			close(tuneDone)
+		}()
+		defer done()
+		defer sb.endRun(run)
+
+		for _, req := range requests {
+			if err := sb.tuneAxis(opCtx, req); err != nil {
+				sb.logger.Errorf("tuning %s failed: %v", tuningTarget(req), err)
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// cancelTuning stops a running start_tuning request and waits for the base to stop.
+func (sb *sensorBase) cancelTuning(ctx context.Context) error {
+	sb.mu.Lock()
+	cancel, tuneDone := sb.tuneCancel, sb.tuneDone
+	sb.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-tuneDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tuneAxis runs the auto-tuner for one axis against the base and swaps the tuned gains into the control loop.
+// If tuning fails or is cancelled, the control loops that were running before are restarted paused.
+func (sb *sensorBase) tuneAxis(ctx context.Context, tr tuningRequest) (err error) {
+	sb.mu.Lock()
+	hadLoop := sb.loop != nil
+	sb.mu.Unlock()
+	// the live loops are stopped so that SetState accepts the tuning loop's outputs
+	sb.stopControlLoop()
+	// always leave the base stopped, even if tuning was cancelled or timed out
+	defer func() {
+		if err := sb.controlledBase.Stop(context.Background(), nil); err != nil {
+			sb.logger.Error(err)
+		}
+	}()
+	defer func() {
+		if err == nil || !hadLoop {
+			return
+		}
+		if restartErr := sb.restartPausedControlLoop(); restartErr != nil {
+			sb.logger.CErrorf(ctx, "could not restore the control loop after tuning stopped: %v", restartErr)
+		}
+	}()
+
+	if tr.axis == latAxis {
+		return sb.tuneLateralAxis(ctx, tr)
+	}
+
+	sb.mu.Lock()
+	tuneConf := sb.tuningConfig(tr)
+	sb.mu.Unlock()
+
+	sb.logger.CInfof(ctx, "tuning %s PID", tuningTarget(tr))
+	loop, err := control.NewLoop(sb.logger, tuneConf, sb)
+	if err != nil {
+		return err
+	}
+	if err := loop.Start(); err != nil {
+		return err
+	}
+	loop.MonitorTuning(ctx)
+	tuned := loop.GetPIDVals(tr.axis)
+	loop.Stop()
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "tuning stopped before it finished")
+	}
+
+	tuned.Type = axisName(tr.axis)
+	sb.logger.CInfof(ctx, "tuned %s PID to %v", tuningTarget(tr), tuned)
+
+	sb.mu.Lock()
+	if tr.band > 0 {
+		sb.schedule.setBandGains(tr.axis, tr.band, tuned)
+	} else {
+		sb.configPIDVals[tr.axis] = tuned
+		(*sb.tunedVals)[tr.axis] = tuned
+	}
+	linear, angular := sb.configPIDVals[0], sb.configPIDVals[1]
+	sb.mu.Unlock()
+
+	// gain_schedule bands are returned by get_tuned_pid to be copied into the config, they are not saved
+	if tr.band == 0 {
+		sb.saveAxisGains(ctx, tuned)
+	}
+
+	if linear.NeedsAutoTuning() || angular.NeedsAutoTuning() {
+		sb.logger.CWarn(ctx, "the other axis has not been tuned yet, tune it before commanding the base")
+		return nil
+	}
+	// rebuild the control loop with the new gains, leaving it paused until the next command
+	if err := sb.setupControlLoop(linear, angular); err != nil {
+		return err
+	}
+	return sb.restartPausedControlLoop()
+}
+
+// tuneLateralAxis tunes the lateral loop of a holonomic base and swaps the tuned gains into it.
+func (sb *sensorBase) tuneLateralAxis(ctx context.Context, tr tuningRequest) error {
+	sb.logger.CInfof(ctx, "tuning %s PID", typeLatVel)
+	tuned, err := sb.tuneLateral(ctx, tr)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "tuning stopped before it finished")
+	}
+
+	tuned.Type = typeLatVel
+	sb.logger.CInfof(ctx, "tuned %s PID to %v", tuned.Type, tuned)
+
+	sb.mu.Lock()
+	sb.lateral.tuned = tuned
+	sb.setupLateralControl(tuned)
+	sb.mu.Unlock()
+	sb.saveAxisGains(ctx, tuned)
+
+	if sb.tuningInProgress() || sb.configPIDVals[0].NeedsAutoTuning() || sb.configPIDVals[1].NeedsAutoTuning() {
+		return nil
+	}
+	return sb.restartPausedControlLoop()
+}
+
+// restartPausedControlLoop starts the control loops with their current config and pauses them until the next command.
+func (sb *sensorBase) restartPausedControlLoop() error {
+	if err := sb.startControlLoop(); err != nil {
+		return err
+	}
+	sb.pauseControlLoop()
+	return nil
+}
+
+// saveAxisGains saves newly tuned gains to the tuned gains file, if one is in use.
+func (sb *sensorBase) saveAxisGains(ctx context.Context, tuned control.PIDConfig) {
+	if sb.gainsFile == "" {
+		return
+	}
+	if err := saveTunedGains(sb.gainsFile, sb.Name().ShortName(), []control.PIDConfig{tuned}); err != nil {
+		sb.logger.CWarnf(ctx, "failed to save tuned gains to %s: %v", sb.gainsFile, err)
+	}
+}
+
+// tuningConfig returns a copy of the control loop config where only the requested axis will be tuned.
+func (sb *sensorBase) tuningConfig(tr tuningRequest) control.Config {
+	tuneConf := control.Config{Frequency: sb.controlLoopConfig.Frequency}
+	pidNames := sb.blockNames[pidBlockType]
+	for _, b := range sb.controlLoopConfig.Blocks {
+		attrs := make(rdkutils.AttributeMap, len(b.Attribute))
+		for k, v := range b.Attribute {
+			attrs[k] = v
+		}
+		switch b.Name {
+		case pidNames[tr.axis]:
+			// all zero gains start the auto-tuner for this block
+			attrs["PIDSets"] = []*control.PIDConfig{{Type: axisName(tr.axis)}}
+			attrs["tune_step_pct"] = tr.stepPct
+		case pidNames[1-tr.axis]:
+			attrs["PIDSets"] = []*control.PIDConfig{{P: untunedAxisGain, I: untunedAxisGain}}
+		}
+		b.Attribute = attrs
+		b.DependsOn = append([]string{}, b.DependsOn...)
+		tuneConf.Blocks = append(tuneConf.Blocks, b)
+	}
+	return tuneConf
+}
+
+// tuningInProgress returns true while the tuning started by a reconfigure has not finished.
+func (sb *sensorBase) tuningInProgress() bool {
+	for i := range sb.configPIDVals {
+		if sb.configPIDVals[i].NeedsAutoTuning() && (*sb.tunedVals)[i].NeedsAutoTuning() {
+			return true
+		}
+	}
+	return false
+}
+
+// axisName returns the control_parameters type of the PID at the given index.
+func axisName(axis int) string {
+	switch axis {
+	case 0:
+		return typeLinVel
+	case 1:
+		return typeAngVel
+	default:
+		return typeLatVel
+	}
+}
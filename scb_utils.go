@@ -32,6 +32,9 @@ func (sb *sensorBase) determineHeadingFunc(ctx context.Context,
 			}
 			// this returns (-180-> 180)
 			yaw := rdkutils.RadToDeg(orient.EulerAngles().Yaw)
+			if err := sb.checkStaleReading(ctx, staleHeading, orientation, yaw); err != nil {
+				return 0, false, err
+			}
 
 			return yaw, true, nil
 		}
@@ -39,19 +42,11 @@ func (sb *sensorBase) determineHeadingFunc(ctx context.Context,
 		sb.logger.CInfof(ctx, "using sensor %s as angular heading sensor for base %v", compassHeading.Name().ShortName(), sb.Name().ShortName())
 
 		sb.headingFunc = func(ctx context.Context) (float64, bool, error) {
-			compass, err := compassHeading.CompassHeading(ctx, nil)
+			heading, err := sb.compassYaw(ctx, compassHeading)
 			if err != nil {
 				return 0, false, err
 			}
-			// flip compass heading to be CCW/Z up
-			compass = 360 - compass
-
-			// make the compass heading (-180->180)
-			if compass > 180 {
-				compass -= 360
-			}
-
-			return compass, true, nil
+			return heading, true, nil
 		}
 	default:
 		sb.logger.CInfof(ctx, "base %v cannot control heading, no heading related sensor given",
@@ -61,3 +56,23 @@ func (sb *sensorBase) determineHeadingFunc(ctx context.Context,
 		}
 	}
 }
+
+// compassYaw reads a compass heading sensor and returns the heading counterclockwise from north,
+// between -180 and 180 degrees, like the yaw headingFunc reads from an orientation sensor.
+func (sb *sensorBase) compassYaw(ctx context.Context, compassHeading movementsensor.MovementSensor) (float64, error) {
+	compass, err := compassHeading.CompassHeading(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := sb.checkStaleReading(ctx, staleHeading, compassHeading, compass); err != nil {
+		return 0, err
+	}
+	// flip compass heading to be CCW/Z up
+	compass = 360 - compass
+
+	// make the compass heading (-180->180)
+	if compass > 180 {
+		compass -= 360
+	}
+	return compass, nil
+}
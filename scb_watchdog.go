@@ -0,0 +1,91 @@
+package controlledcomponents
+
+import (
+	"context"
+	"time"
+
+	"go.viam.com/utils"
+)
+
+// watchdogRampTime is how long the command watchdog takes to ramp the setpoints of a silent SetVelocity session to zero.
+const watchdogRampTime = 500 * time.Millisecond
+
+// startWatchdog stops the SetVelocity session in the background if no new command replaces it within the
+// velocity_command_timeout, so that a base whose client has disconnected does not drive on forever.
+// The setpoints are ramped down to zero through the control loop before the loop is paused and the base is stopped.
+// The watchdog runs until stopWatchdog is called by the next command.
+func (sb *sensorBase) startWatchdog(lateral float64) {
+	timeout := sb.safety.commandTimeout()
+	if timeout == 0 {
+		return
+	}
+	watchCtx, watchCancel := context.WithCancel(sb.cancelCtx)
+	watchDone := make(chan struct{})
+	sb.mu.Lock()
+	sb.watchdogCancel = watchCancel
+	sb.watchdogDone = watchDone
+	sb.mu.Unlock()
+
+	sb.activeBackgroundWorkers.Add(1)
+	utils.ManagedGo(func() {
+		defer close(watchDone)
+		if !utils.SelectContextOrWait(watchCtx, timeout) {
+			return
+		}
+		// a base that a safety check has already stopped is left alone
+		if sb.loop == nil || !sb.loop.Running() {
+			return
+		}
+		sb.logger.CWarnf(watchCtx, "no SetVelocity within the velocity_command_timeout of %v, stopping the base", timeout)
+		sb.safety.noteWatchdog(time.Now())
+		if err := sb.rampToStop(watchCtx, lateral); err != nil {
+			if watchCtx.Err() == nil {
+				sb.logger.Errorf("the watchdog could not stop the base: %v", err)
+			}
+		}
+	}, sb.activeBackgroundWorkers.Done)
+}
+
+// rampToStop ramps the setpoints of the control loop from where they are to zero over watchdogRampTime,
+// then pauses the loop and stops the base. The lateral setpoint is not noted, so it is passed in.
+func (sb *sensorBase) rampToStop(ctx context.Context, lateral float64) error {
+	// the heading hold would steer the angular setpoint away from the ramp
+	sb.stopHeadingHold()
+	current, _ := sb.history.snapshot(0)
+	ticker := time.NewTicker(time.Duration(1000./sb.controlFreq) * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		remaining := max(0, 1-time.Since(start).Seconds()/watchdogRampTime.Seconds())
+		if err := sb.updateVelocitySetpoints(ctx,
+			lateral*remaining, current.linearSetpoint*remaining, current.angularSetpoint*remaining); err != nil {
+			return err
+		}
+		if remaining == 0 {
+			break
+		}
+	}
+	sb.pauseControlLoop()
+	sb.stopRun()
+	return sb.controlledBase.Stop(ctx, nil)
+}
+
+// stopWatchdog disarms the watchdog of the previous SetVelocity session and waits for any ramp it started to
+// stop, so that it cannot overwrite the setpoints of the command that replaced it.
+func (sb *sensorBase) stopWatchdog() {
+	sb.mu.Lock()
+	cancel, watchDone := sb.watchdogCancel, sb.watchdogDone
+	sb.watchdogCancel = nil
+	sb.watchdogDone = nil
+	sb.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-watchDone
+}
@@ -0,0 +1,248 @@
+package controlledcomponents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	geo "github.com/kellydunn/golang-geo"
+	"go.viam.com/utils"
+)
+
+const (
+	followWaypoints       = "follow_waypoints"
+	getWaypointProgress   = "get_waypoint_progress"
+	defaultArrivalRadiusM = 1.
+
+	waypointsRunning = "running"
+	waypointsArrived = "arrived"
+	waypointsStopped = "stopped"
+	waypointsFailed  = "failed"
+)
+
+// waypoint is one leg of a follow_waypoints request.
+type waypoint struct {
+	point          *geo.Point
+	mmPerSec       float64
+	arrivalRadiusM float64
+}
+
+// waypointProgress reports how far along its waypoints the base is.
+type waypointProgress struct {
+	state     string
+	index     int
+	total     int
+	distanceM float64
+	err       error
+}
+
+// parseWaypoints reads the waypoints of a follow_waypoints DoCommand. Each waypoint may set its own
+// mm_per_sec and arrival_radius_m, otherwise the values given for the whole request are used.
+func parseWaypoints(raw interface{}) ([]waypoint, error) {
+	opts, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("follow_waypoints requires an object with a list of waypoints")
+	}
+	defaults := waypoint{mmPerSec: defaultPoseMmPerSec, arrivalRadiusM: defaultArrivalRadiusM}
+	if err := parseWaypointOptions(opts, &defaults); err != nil {
+		return nil, err
+	}
+
+	rawPoints, ok := opts["waypoints"].([]interface{})
+	if !ok || len(rawPoints) == 0 {
+		return nil, errors.New("follow_waypoints requires at least one waypoint")
+	}
+	waypoints := make([]waypoint, 0, len(rawPoints))
+	for i, rawPoint := range rawPoints {
+		pointOpts, ok := rawPoint.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("follow_waypoints waypoint %d must be an object", i)
+		}
+		lat, latOk := pointOpts["lat"].(float64)
+		lng, lngOk := pointOpts["lng"].(float64)
+		if !latOk || !lngOk {
+			return nil, fmt.Errorf("follow_waypoints waypoint %d requires a lat and lng", i)
+		}
+		wp := defaults
+		wp.point = geo.NewPoint(lat, lng)
+		if err := parseWaypointOptions(pointOpts, &wp); err != nil {
+			return nil, err
+		}
+		waypoints = append(waypoints, wp)
+	}
+	return waypoints, nil
+}
+
+func parseWaypointOptions(opts map[string]interface{}, wp *waypoint) error {
+	for name, value := range map[string]*float64{"mm_per_sec": &wp.mmPerSec, "arrival_radius_m": &wp.arrivalRadiusM} {
+		raw, ok := opts[name]
+		if !ok {
+			continue
+		}
+		v, ok := raw.(float64)
+		if !ok || v <= 0 {
+			return fmt.Errorf("follow_waypoints %s must be a number greater than 0", name)
+		}
+		*value = v
+	}
+	return nil
+}
+
+// startWaypoints begins following the waypoints in the background. Like any other motion,
+// it replaces the running operation and is cancelled by the next command or by Stop.
+func (sb *sensorBase) startWaypoints(ctx context.Context, waypoints []waypoint) error {
+	if sb.controlLoopConfig == nil {
+		return errors.New("follow_waypoints requires control_parameters to be configured")
+	}
+	if sb.position == nil || sb.positionFrame != frameGeodetic {
+		return errors.New("follow_waypoints requires a geodetic position sensor")
+	}
+	// the waypoints are fixed points on the earth, so the base has to be steered by its heading from north.
+	// headingFunc prefers an orientation sensor, whose yaw is only relative to where the sensor started.
+	if sb.compass == nil {
+		return errors.New("follow_waypoints requires a compass heading sensor, as it steers by the heading of the base from north")
+	}
+	if err := sb.checkTuningStatus(); err != nil {
+		return err
+	}
+	if err := sb.checkLevel(ctx); err != nil {
+		return err
+	}
+	if sb.geofence != nil {
+		for i, wp := range waypoints {
+			if outside := sb.geofence.outsideMm(wp.point, sb.displacementMm); outside > 0 {
+				return sb.refuseOutsideGeofence(ctx, fmt.Sprintf("follow_waypoints waypoint %d is %.1f m outside the geofence", i, outside/1000))
+			}
+		}
+	}
+
+	sb.opMgr.CancelRunning(ctx)
+	sb.stopHeadingHold()
+	sb.stopWatchdog()
+	opCtx, done := sb.newOperation(sb.cancelCtx)
+
+	sb.mu.Lock()
+	sb.waypoints = waypointProgress{state: waypointsRunning, total: len(waypoints)}
+	sb.mu.Unlock()
+
+	sb.activeBackgroundWorkers.Add(1)
+	utils.PanicCapturingGo(func() {
+		defer sb.activeBackgroundWorkers.Done()
+		defer done()
+		err := sb.followWaypoints(opCtx, waypoints)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			// leave the base stopped rather than driving on at the last setpoint
+			if stopErr := sb.Stop(opCtx, nil); stopErr != nil {
+				sb.logger.Error(stopErr)
+			}
+		}
+
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+		switch {
+		case err == nil:
+			sb.waypoints.state = waypointsArrived
+		case errors.Is(err, context.Canceled):
+			sb.waypoints.state = waypointsStopped
+		default:
+			sb.logger.Errorf("following waypoints failed: %v", err)
+			sb.waypoints.state = waypointsFailed
+			sb.waypoints.err = err
+		}
+	})
+	return nil
+}
+
+// followWaypoints steers the base towards each waypoint in turn, moving on once it is within the arrival radius.
+func (sb *sensorBase) followWaypoints(ctx context.Context, waypoints []waypoint) error {
+	tunables, err := sb.motionTunables(nil)
+	if err != nil {
+		return err
+	}
+	if sb.loop == nil {
+		if err := sb.startControlLoop(); err != nil {
+			return err
+		}
+	}
+	sb.resetControlLoop()
+
+	run := sb.startRun(ctx, followWaypoints, map[string]interface{}{"waypoints": len(waypoints)})
+	defer sb.endRun(run)
+
+	ticker := time.NewTicker(time.Duration(1000./sb.controlLoopConfig.Frequency) * time.Millisecond)
+	defer ticker.Stop()
+	for i, wp := range waypoints {
+		pos, err := sb.readPosition(ctx)
+		if err != nil {
+			return err
+		}
+		legSecs := pos.GreatCircleDistance(wp.point) * 1000000. / wp.mmPerSec
+		legTimeout := tunables.timeout(time.Duration(legSecs * float64(time.Second)))
+		legStart := time.Now()
+		// only slow down for the last waypoint, the base drives through the others
+		slowDownDist := 1.
+		if i == len(waypoints)-1 {
+			slowDownDist = tunables.maxStraightSlowDownMm
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			case <-ticker.C:
+			}
+
+			pos, err := sb.readPosition(ctx)
+			if err != nil {
+				return err
+			}
+			heading, err := sb.compassYaw(ctx, sb.compass)
+			if err != nil {
+				return err
+			}
+			toTarget := sb.displacementMm(pos, wp.point)
+			sb.history.noteHeading(heading)
+			sb.history.notePositionErr(toTarget.Norm())
+
+			sb.mu.Lock()
+			sb.waypoints.index = i
+			sb.waypoints.distanceM = toTarget.Norm() / 1000
+			sb.mu.Unlock()
+
+			if toTarget.Norm() <= wp.arrivalRadiusM*1000 {
+				sb.logger.CInfof(ctx, "arrived at waypoint %d of %d", i+1, len(waypoints))
+				break
+			}
+			if time.Since(legStart) > legTimeout {
+				return fmt.Errorf("timed out before reaching waypoint %d", i+1)
+			}
+
+			linVel, angVel := purePursuit(toTarget, heading, wp.mmPerSec, defaultPoseDegsPerSec, math.Max(slowDownDist, 1))
+			if err := sb.updateControlConfig(ctx, linVel/1000.0, angVel); err != nil {
+				return err
+			}
+		}
+	}
+	return sb.Stop(ctx, nil)
+}
+
+// waypointStatus returns the progress of the latest follow_waypoints request.
+func (sb *sensorBase) waypointStatus() (map[string]interface{}, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	if sb.waypoints.state == "" {
+		return nil, errors.New("follow_waypoints has not been called")
+	}
+	status := map[string]interface{}{
+		"state":      sb.waypoints.state,
+		"index":      sb.waypoints.index,
+		"total":      sb.waypoints.total,
+		"distance_m": sb.waypoints.distanceM,
+	}
+	if sb.waypoints.err != nil {
+		status["error"] = sb.waypoints.err.Error()
+	}
+	return status, nil
+}